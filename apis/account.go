@@ -14,6 +14,9 @@ func init() {
 	{
 		account.GET("", middlewares.PaginationMiddleware(), ListAccount)
 		account.POST("", middlewares.ActionMiddleware(), AddAccount)
+		account.PATCH(":id", middlewares.ActionMiddleware(), UpdateAccount)
+		account.DELETE(":id", middlewares.ActionMiddleware(), DeleteAccount)
+		account.POST(":id/restore", middlewares.ActionMiddleware(), RestoreAccount)
 	}
 }
 
@@ -23,6 +26,7 @@ func init() {
 // @Tags Account
 // @Accept  json
 // @Produce  json
+// @Param unscoped query bool false "list deleted accounts too"
 // @Success 200 {object} dto.ListAccountReq
 // @Failure 400 {object} middlewares.ErrJSONDto
 // @Failure 500 {object} middlewares.ErrJSONDto
@@ -34,7 +38,7 @@ func ListAccount(c *gin.Context) {
 		return
 	}
 
-	rsp, err := s.List(c.MustGet("pagination").(dto.Pagination))
+	rsp, err := s.List(c.Request.Context(), c.MustGet("pagination").(dto.Pagination))
 	if err != nil {
 		C{c}.SetErr(err, http.StatusInternalServerError)
 		return
@@ -61,7 +65,109 @@ func AddAccount(c *gin.Context) {
 		return
 	}
 
-	rsp, err := s.Add()
+	// outbound calls are canceled once the client goes away
+	rsp, err := s.Add(c.Request.Context())
+	if err != nil {
+		C{c}.SetErr(err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, &rsp)
+}
+
+// UpdateAccount godoc
+// @Summary Update an account
+// @Description updates given fields of an account, rejected if it changed since the version of If-Match or body
+// @Tags Account
+// @Accept  json
+// @Produce  json
+// @Param id path int true "account id"
+// @Param If-Match header string false "version tag of the account read, e.g. \"3\""
+// @Param data body dto.UpdateAccountReq true "data"
+// @Success 200 {object} models.Account
+// @Failure 400 {object} middlewares.ErrJSONDto
+// @Failure 404 {object} middlewares.ErrJSONDto
+// @Failure 409 {object} middlewares.ErrJSONDto
+// @Failure 500 {object} middlewares.ErrJSONDto
+// @Router /accounts/{id} [patch]
+func UpdateAccount(c *gin.Context) {
+	s := dto.UpdateAccountReq{}
+	if err := c.ShouldBindUri(&s); err != nil {
+		C{c}.SetErr(err, http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&s); err != nil {
+		C{c}.SetErr(err, http.StatusBadRequest)
+		return
+	}
+
+	version, ok, err := C{c}.IfMatchVersion()
+	if err != nil {
+		C{c}.SetErr(err, http.StatusBadRequest)
+		return
+	}
+	if ok {
+		s.Version = version
+	}
+
+	rsp, err := s.Update(c.Request.Context())
+	if err != nil {
+		C{c}.SetErr(err, http.StatusInternalServerError)
+		return
+	}
+
+	C{c}.SetETag(rsp.Version)
+	c.JSON(http.StatusOK, &rsp)
+}
+
+// DeleteAccount godoc
+// @Summary Delete an account
+// @Description soft deletes an account, it can be restored
+// @Tags Account
+// @Accept  json
+// @Produce  json
+// @Param id path int true "account id"
+// @Success 200 {object} dto.BaseRsp
+// @Failure 404 {object} middlewares.ErrJSONDto
+// @Failure 500 {object} middlewares.ErrJSONDto
+// @Router /accounts/{id} [delete]
+func DeleteAccount(c *gin.Context) {
+	s := dto.DeleteAccountReq{}
+	if err := c.ShouldBindUri(&s); err != nil {
+		C{c}.SetErr(err, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Delete(c.Request.Context()); err != nil {
+		C{c}.SetErr(err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.BaseRsp{
+		Message: "ok",
+	})
+}
+
+// RestoreAccount godoc
+// @Summary Restore an account
+// @Description restores a deleted account
+// @Tags Account
+// @Accept  json
+// @Produce  json
+// @Param id path int true "account id"
+// @Success 200 {object} models.Account
+// @Failure 404 {object} middlewares.ErrJSONDto
+// @Failure 500 {object} middlewares.ErrJSONDto
+// @Router /accounts/{id}/restore [post]
+func RestoreAccount(c *gin.Context) {
+	s := dto.RestoreAccountReq{}
+	if err := c.ShouldBindUri(&s); err != nil {
+		C{c}.SetErr(err, http.StatusBadRequest)
+		return
+	}
+
+	rsp, err := s.Restore(c.Request.Context())
 	if err != nil {
 		C{c}.SetErr(err, http.StatusInternalServerError)
 		return
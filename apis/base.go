@@ -5,12 +5,16 @@ import (
 	"go-cygnus/dto"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 
 	"go-cygnus/middlewares"
+	"go-cygnus/utils/db"
 	"go-cygnus/utils/logging"
+	"go-cygnus/utils/metrics"
 	"go-cygnus/utils/server"
 )
 
@@ -30,6 +34,7 @@ var (
 
 func init() {
 	pprof.Register(WebAPIServer.Engine)
+	WebAPIServer.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	v1Router.GET("health/check", HealthCheck)
 }
@@ -92,3 +97,28 @@ func (c C) SetErr(err error, code ...int) {
 
 	_ = c.Error(w)
 }
+
+// IfMatchVersion parses If-Match header as the version of a model, ok is false without it or with *
+func (c C) IfMatchVersion() (version db.Version, ok bool, err error) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return
+	}
+
+	tag, err := strconv.Unquote(strings.TrimPrefix(ifMatch, "W/"))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match %s, a single version tag like \"3\" is expected", ifMatch)
+	}
+
+	parsed, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match %s, a single version tag like \"3\" is expected", ifMatch)
+	}
+
+	return db.Version(parsed), true, nil
+}
+
+// SetETag responds version as ETag, clients send it back by If-Match to update the model
+func (c C) SetETag(version db.Version) {
+	c.Header("ETag", strconv.Quote(strconv.FormatInt(int64(version), 10)))
+}
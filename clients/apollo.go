@@ -1,33 +1,110 @@
 package clients
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 )
 
+// apollo is the client of apollo portal open api,
+// ref https://www.apolloconfig.com/#/zh/usage/apollo-open-api-platform
 type apollo struct {
 	baseRest
-	Token string
 }
 
+const (
+	apolloURIBase  = "openapi/v1"
+	apolloEndpoint = "apollo"
+)
+
+// Apollo is the client named apollo in clients.yml, base_uri defaults to openapi/v1
 func Apollo() *apollo {
-	if RestConfigs.ApolloConfig == nil {
-		panic("no apollo config")
+	a := &apollo{baseRest: *Get(apolloEndpoint)}
+	if a.URIBase == "" {
+		a.URIBase = apolloURIBase
 	}
 
-	apolloConfig := defaultHTTPConfig
-	apolloConfig.Headers["Authorization"] = RestConfigs.ApolloConfig.Token
+	return a
+}
 
-	return &apollo{
-		baseRest: baseRest{
-			Scheme:  RestConfigs.ApolloConfig.Scheme,
-			Host:    RestConfigs.ApolloConfig.Hostname,
-			URIBase: "openapi/v1",
-			Config:  apolloConfig,
-		},
+// NewApollo creates an apollo client without clients.yml, e.g. against apollotest.Server
+func NewApollo(scheme string, hostname string, token string) *apollo {
+	endpoint := endpointConfig{Scheme: scheme, Hostname: hostname, URIBase: apolloURIBase, Token: token}
+
+	rest, err := endpoint.build(apolloEndpoint, &RestConfigs)
+	if err != nil {
+		panic(fmt.Sprintf("invalid apollo config: %s", err))
 	}
+
+	return &apollo{baseRest: *rest}
+}
+
+// ApolloEnvClusters is the clusters of an app in one env
+type ApolloEnvClusters struct {
+	Env      string   `json:"env"`
+	Clusters []string `json:"clusters"`
+}
+
+// ApolloCluster is the cluster info of an app in one env
+type ApolloCluster struct {
+	Name                       string `json:"name"`
+	AppID                      string `json:"appId"`
+	DataChangeCreatedBy        string `json:"dataChangeCreatedBy"`
+	DataChangeLastModifiedBy   string `json:"dataChangeLastModifiedBy"`
+	DataChangeCreatedTime      string `json:"dataChangeCreatedTime"`
+	DataChangeLastModifiedTime string `json:"dataChangeLastModifiedTime"`
 }
 
+type ApolloItem struct {
+	Key                        string `json:"key"`
+	Value                      string `json:"value"`
+	Comment                    string `json:"comment,omitempty"`
+	DataChangeCreatedBy        string `json:"dataChangeCreatedBy,omitempty"`
+	DataChangeLastModifiedBy   string `json:"dataChangeLastModifiedBy,omitempty"`
+	DataChangeCreatedTime      string `json:"dataChangeCreatedTime,omitempty"`
+	DataChangeLastModifiedTime string `json:"dataChangeLastModifiedTime,omitempty"`
+}
+
+type ApolloNamespace struct {
+	AppID                      string       `json:"appId"`
+	ClusterName                string       `json:"clusterName"`
+	NamespaceName              string       `json:"namespaceName"`
+	Comment                    string       `json:"comment"`
+	Format                     string       `json:"format"`
+	IsPublic                   bool         `json:"isPublic"`
+	Items                      []ApolloItem `json:"items"`
+	DataChangeCreatedBy        string       `json:"dataChangeCreatedBy"`
+	DataChangeLastModifiedBy   string       `json:"dataChangeLastModifiedBy"`
+	DataChangeCreatedTime      string       `json:"dataChangeCreatedTime"`
+	DataChangeLastModifiedTime string       `json:"dataChangeLastModifiedTime"`
+}
+
+// ApolloAppNamespace is the namespace definition of an app, shared by its every env and cluster
+type ApolloAppNamespace struct {
+	Name                string `json:"name"`
+	AppID               string `json:"appId"`
+	Format              string `json:"format"`
+	IsPublic            bool   `json:"isPublic"`
+	Comment             string `json:"comment,omitempty"`
+	DataChangeCreatedBy string `json:"dataChangeCreatedBy"`
+}
+
+type ApolloRelease struct {
+	ID                         int64             `json:"id"`
+	AppID                      string            `json:"appId"`
+	ClusterName                string            `json:"clusterName"`
+	NamespaceName              string            `json:"namespaceName"`
+	Name                       string            `json:"name"`
+	Configurations             map[string]string `json:"configurations"`
+	Comment                    string            `json:"comment"`
+	DataChangeCreatedBy        string            `json:"dataChangeCreatedBy"`
+	DataChangeLastModifiedBy   string            `json:"dataChangeLastModifiedBy"`
+	DataChangeCreatedTime      string            `json:"dataChangeCreatedTime"`
+	DataChangeLastModifiedTime string            `json:"dataChangeLastModifiedTime"`
+}
+
+// GetNamespaceInfoReq locates a namespace, all namespace scoped requests embed it
 type GetNamespaceInfoReq struct {
 	Env           string
 	AppID         string
@@ -35,9 +112,177 @@ type GetNamespaceInfoReq struct {
 	NamespaceName string
 }
 
-func (a *apollo) GetNamespaceInfo(req GetNamespaceInfoReq) (rsp map[string]interface{}, err error) {
-	subURL := fmt.Sprintf("envs/%s/apps/%s/clusters/%s/namespaces/%s/",
-		req.Env, req.AppID, req.ClusterName, req.NamespaceName)
-	err = a.Json(http.MethodPost, subURL, &req, &rsp)
+func (r *GetNamespaceInfoReq) subURL() string {
+	return fmt.Sprintf("envs/%s/apps/%s/clusters/%s/namespaces/%s",
+		url.PathEscape(r.Env), url.PathEscape(r.AppID), url.PathEscape(r.ClusterName), url.PathEscape(r.NamespaceName))
+}
+
+func (a *apollo) GetNamespaceInfo(ctx context.Context, req GetNamespaceInfoReq) (rsp ApolloNamespace, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}")
+	err = a.JsonWithContext(ctx, http.MethodGet, req.subURL(), nil, &rsp)
+	return
+}
+
+// GetNamespaceInfos gets namespaces concurrently, results and errors are in the order of reqs
+func (a *apollo) GetNamespaceInfos(ctx context.Context, reqs []GetNamespaceInfoReq) ([]ApolloNamespace, []error) {
+	return FanOut(ctx, reqs, FanOutOptions{}, a.GetNamespaceInfo)
+}
+
+func (a *apollo) ListEnvClusters(ctx context.Context, appID string) (rsp []ApolloEnvClusters, err error) {
+	ctx = WithRoute(ctx, "apps/{app_id}/envclusters")
+	subURL := fmt.Sprintf("apps/%s/envclusters", url.PathEscape(appID))
+	err = a.JsonWithContext(ctx, http.MethodGet, subURL, nil, &rsp)
+	return
+}
+
+type GetClusterReq struct {
+	Env         string
+	AppID       string
+	ClusterName string
+}
+
+func (a *apollo) GetCluster(ctx context.Context, req GetClusterReq) (rsp ApolloCluster, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}")
+	subURL := fmt.Sprintf("envs/%s/apps/%s/clusters/%s",
+		url.PathEscape(req.Env), url.PathEscape(req.AppID), url.PathEscape(req.ClusterName))
+	err = a.JsonWithContext(ctx, http.MethodGet, subURL, nil, &rsp)
 	return
 }
+
+type GetItemReq struct {
+	GetNamespaceInfoReq
+	Key string
+}
+
+func (a *apollo) GetItem(ctx context.Context, req GetItemReq) (rsp ApolloItem, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/items/{key}")
+	subURL := fmt.Sprintf("%s/items/%s", req.subURL(), url.PathEscape(req.Key))
+	err = a.JsonWithContext(ctx, http.MethodGet, subURL, nil, &rsp)
+	return
+}
+
+type CreateItemReq struct {
+	GetNamespaceInfoReq
+	Key      string
+	Value    string
+	Comment  string
+	Operator string
+}
+
+func (a *apollo) CreateItem(ctx context.Context, req CreateItemReq) (rsp ApolloItem, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/items")
+	payload := ApolloItem{
+		Key:                 req.Key,
+		Value:               req.Value,
+		Comment:             req.Comment,
+		DataChangeCreatedBy: req.Operator,
+	}
+	err = a.JsonWithContext(ctx, http.MethodPost, req.subURL()+"/items", &payload, &rsp)
+	return
+}
+
+type UpdateItemReq struct {
+	GetNamespaceInfoReq
+	Key               string
+	Value             string
+	Comment           string
+	Operator          string
+	CreateIfNotExists bool
+}
+
+func (a *apollo) UpdateItem(ctx context.Context, req UpdateItemReq) (err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/items/{key}")
+	subURL := fmt.Sprintf("%s/items/%s?createIfNotExists=%t", req.subURL(), url.PathEscape(req.Key), req.CreateIfNotExists)
+	payload := ApolloItem{
+		Key:                      req.Key,
+		Value:                    req.Value,
+		Comment:                  req.Comment,
+		DataChangeLastModifiedBy: req.Operator,
+	}
+
+	// apollo requires dataChangeCreatedBy when the item may be created
+	if req.CreateIfNotExists {
+		payload.DataChangeCreatedBy = req.Operator
+	}
+
+	return a.JsonWithContext(ctx, http.MethodPut, subURL, &payload, nil)
+}
+
+type DeleteItemReq struct {
+	GetNamespaceInfoReq
+	Key      string
+	Operator string
+}
+
+func (a *apollo) DeleteItem(ctx context.Context, req DeleteItemReq) (err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/items/{key}")
+	subURL := fmt.Sprintf("%s/items/%s?operator=%s",
+		req.subURL(), url.PathEscape(req.Key), url.QueryEscape(req.Operator))
+	return a.JsonWithContext(ctx, http.MethodDelete, subURL, nil, nil)
+}
+
+type CreateNamespaceReq struct {
+	AppID    string
+	Name     string
+	Format   string // properties, xml, json, yml, yaml
+	IsPublic bool
+	Comment  string
+	Operator string
+}
+
+func (a *apollo) CreateNamespace(ctx context.Context, req CreateNamespaceReq) (rsp ApolloAppNamespace, err error) {
+	ctx = WithRoute(ctx, "apps/{app_id}/appnamespaces")
+	subURL := fmt.Sprintf("apps/%s/appnamespaces", url.PathEscape(req.AppID))
+	payload := ApolloAppNamespace{
+		Name:                req.Name,
+		AppID:               req.AppID,
+		Format:              req.Format,
+		IsPublic:            req.IsPublic,
+		Comment:             req.Comment,
+		DataChangeCreatedBy: req.Operator,
+	}
+	err = a.JsonWithContext(ctx, http.MethodPost, subURL, &payload, &rsp)
+	return
+}
+
+type ReleaseNamespaceReq struct {
+	GetNamespaceInfoReq
+	Title    string
+	Comment  string
+	Operator string
+}
+
+func (a *apollo) ReleaseNamespace(ctx context.Context, req ReleaseNamespaceReq) (rsp ApolloRelease, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/releases")
+	payload := struct {
+		ReleaseTitle   string `json:"releaseTitle"`
+		ReleaseComment string `json:"releaseComment,omitempty"`
+		ReleasedBy     string `json:"releasedBy"`
+	}{
+		ReleaseTitle:   req.Title,
+		ReleaseComment: req.Comment,
+		ReleasedBy:     req.Operator,
+	}
+	err = a.JsonWithContext(ctx, http.MethodPost, req.subURL()+"/releases", &payload, &rsp)
+	return
+}
+
+func (a *apollo) GetLatestRelease(ctx context.Context, req GetNamespaceInfoReq) (rsp ApolloRelease, err error) {
+	ctx = WithRoute(ctx, "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/releases/latest")
+	err = a.JsonWithContext(ctx, http.MethodGet, req.subURL()+"/releases/latest", nil, &rsp)
+	return
+}
+
+type RollbackReleaseReq struct {
+	Env       string
+	ReleaseID int64
+	Operator  string
+}
+
+func (a *apollo) RollbackRelease(ctx context.Context, req RollbackReleaseReq) (err error) {
+	// a rollback applied before a timeout or 502 would be rolled back again by a retry
+	ctx = WithoutRetry(WithRoute(ctx, "envs/{env}/releases/{release_id}/rollback"))
+	subURL := fmt.Sprintf("envs/%s/releases/%d/rollback?operator=%s",
+		url.PathEscape(req.Env), req.ReleaseID, url.QueryEscape(req.Operator))
+	return a.JsonWithContext(ctx, http.MethodPut, subURL, nil, nil)
+}
@@ -0,0 +1,416 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultApolloCluster   = "default"
+	DefaultApolloNamespace = "application"
+	DefaultApolloCacheDir  = "./cache/apollo"
+
+	// config service holds a notification request up to 60s
+	apolloLongPollTimeoutSecond = 90
+	apolloPollMinBackoff        = time.Second
+	apolloPollMaxBackoff        = 2 * time.Minute
+	apolloInitNotificationID    = -1
+
+	// DefaultApolloConsumerEndpoint is the endpoint name of config service in clients.yml
+	DefaultApolloConsumerEndpoint = "apollo_config"
+)
+
+// apolloConsumerConfig reads from config service declared in endpoints, whose token is the access key secret
+type apolloConsumerConfig struct {
+	Endpoint   string   `yaml:"endpoint"`
+	AppID      string   `yaml:"app_id" validate:"required"`
+	Cluster    string   `yaml:"cluster"`
+	Namespaces []string `yaml:"namespaces"`
+	CacheDir   string   `yaml:"cache_dir"`
+}
+
+type ConfigChangeType int
+
+const (
+	ConfigAdded ConfigChangeType = iota
+	ConfigModified
+	ConfigDeleted
+)
+
+type ConfigChange struct {
+	Namespace string
+	Key       string
+	OldValue  string
+	NewValue  string
+	Type      ConfigChangeType
+}
+
+// ConfigChangeFunc is called in the polling goroutine, it should return quickly
+type ConfigChangeFunc func(change ConfigChange)
+
+type apolloSubscription struct {
+	namespace string
+	key       string // empty for every key of namespace
+	callback  ConfigChangeFunc
+}
+
+// ApolloNamespaceConfig is what config service /configs returns, also the on-disk cache format
+type ApolloNamespaceConfig struct {
+	AppID          string            `json:"appId"`
+	Cluster        string            `json:"cluster"`
+	NamespaceName  string            `json:"namespaceName"`
+	Configurations map[string]string `json:"configurations"`
+	ReleaseKey     string            `json:"releaseKey"`
+}
+
+type apolloNotification struct {
+	NamespaceName  string `json:"namespaceName"`
+	NotificationID int64  `json:"notificationId"`
+}
+
+// apolloConsumer reads configs from apollo config service and keeps them fresh by long polling,
+// ref https://www.apolloconfig.com/#/zh/client/other-language-client-user-guide
+type apolloConsumer struct {
+	baseRest
+	AppID      string
+	Cluster    string
+	Namespaces []string
+	CacheDir   string
+
+	mu              sync.RWMutex
+	configs         map[string]*ApolloNamespaceConfig
+	notificationIDs map[string]int64
+	subscriptions   []apolloSubscription
+}
+
+func ApolloConsumer() *apolloConsumer {
+	conf := RestConfigs.ApolloConsumerConfig
+	if conf == nil {
+		panic("no apollo config service config")
+	}
+
+	endpointName := conf.Endpoint
+	if endpointName == "" {
+		endpointName = DefaultApolloConsumerEndpoint
+	}
+
+	c := &apolloConsumer{
+		baseRest:        *Get(endpointName),
+		AppID:           conf.AppID,
+		Cluster:         conf.Cluster,
+		Namespaces:      conf.Namespaces,
+		CacheDir:        conf.CacheDir,
+		configs:         make(map[string]*ApolloNamespaceConfig),
+		notificationIDs: make(map[string]int64),
+	}
+
+	if c.Cluster == "" {
+		c.Cluster = DefaultApolloCluster
+	}
+	if len(c.Namespaces) == 0 {
+		c.Namespaces = []string{DefaultApolloNamespace}
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = DefaultApolloCacheDir
+	}
+
+	for _, ns := range c.Namespaces {
+		c.notificationIDs[ns] = apolloInitNotificationID
+	}
+
+	// the plain token of config service is the access key secret, apps without one are open
+	if endpoint := RestConfigs.Endpoints[endpointName]; endpoint != nil && endpoint.Auth == nil && endpoint.Token != "" {
+		c.Auth = &ApolloAuth{AppID: c.AppID, Secret: endpoint.Token}
+	}
+
+	return c
+}
+
+// Start loads every namespace then keeps polling changes until ctx is done.
+// A namespace the config service cannot serve is loaded from local cache, it fails only if both are missing.
+func (c *apolloConsumer) Start(ctx context.Context) error {
+	for _, ns := range c.Namespaces {
+		if _, err := c.refresh(ctx, ns); err != nil {
+			return err
+		}
+	}
+
+	go c.poll(ctx)
+
+	return nil
+}
+
+// Get returns the value of key in namespace
+func (c *apolloConsumer) Get(namespace string, key string) (value string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if conf, exists := c.configs[namespace]; exists {
+		value, ok = conf.Configurations[key]
+	}
+
+	return
+}
+
+// GetAll returns a copy of every config of namespace
+func (c *apolloConsumer) GetAll(namespace string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	all := make(map[string]string)
+	if conf, exists := c.configs[namespace]; exists {
+		for k, v := range conf.Configurations {
+			all[k] = v
+		}
+	}
+
+	return all
+}
+
+// Subscribe calls callback when key of namespace changes, empty key subscribes to the whole namespace
+func (c *apolloConsumer) Subscribe(namespace string, key string, callback ConfigChangeFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscriptions = append(c.subscriptions, apolloSubscription{namespace: namespace, key: key, callback: callback})
+}
+
+func (c *apolloConsumer) poll(ctx context.Context) {
+	backoff := apolloPollMinBackoff
+
+	for ctx.Err() == nil {
+		changed, err := c.waitNotifications(ctx)
+		if err == nil && !c.applyNotifications(ctx, changed) {
+			// the next long poll returns at once with the pending ones
+			err = errors.New("apollo namespaces not refreshed")
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logger.WithError(err).WithField("backoff", backoff.String()).Warn("apollo long polling failed")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > apolloPollMaxBackoff {
+				backoff = apolloPollMaxBackoff
+			}
+
+			continue
+		}
+
+		backoff = apolloPollMinBackoff
+	}
+}
+
+// applyNotifications refreshes changed namespaces, a notification id only advances once its config is fetched,
+// so a change missed now is notified again by the next long poll. done is false if any is left pending.
+func (c *apolloConsumer) applyNotifications(ctx context.Context, changed []apolloNotification) (done bool) {
+	done = true
+
+	for _, n := range changed {
+		fromCache, err := c.refresh(ctx, n.NamespaceName)
+		if err != nil {
+			logger.WithError(err).WithField("namespace", n.NamespaceName).Warn("apollo refresh failed")
+		}
+		if err != nil || fromCache {
+			done = false
+			continue
+		}
+
+		c.mu.Lock()
+		c.notificationIDs[n.NamespaceName] = n.NotificationID
+		c.mu.Unlock()
+	}
+
+	return
+}
+
+// waitNotifications long polls config service, returns nothing if no namespace changed in time
+func (c *apolloConsumer) waitNotifications(ctx context.Context) (changed []apolloNotification, err error) {
+	c.mu.RLock()
+	notifications := make([]apolloNotification, 0, len(c.notificationIDs))
+	for ns, id := range c.notificationIDs {
+		notifications = append(notifications, apolloNotification{NamespaceName: ns, NotificationID: id})
+	}
+	c.mu.RUnlock()
+
+	notificationsJSON, err := json.Marshal(notifications)
+	if err != nil {
+		return
+	}
+
+	query := url.Values{}
+	query.Set("appId", c.AppID)
+	query.Set("cluster", c.Cluster)
+	query.Set("notifications", string(notificationsJSON))
+
+	longPoll := c.baseRest
+	longPoll.Config.Timeout = apolloLongPollTimeoutSecond
+	longPoll.Config.RetryTimes = 0
+
+	err = c.get(WithRoute(ctx, "notifications/v2"), &longPoll, "notifications/v2?"+query.Encode(), &changed)
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+
+	return
+}
+
+// refresh fetches namespace from config service, fromCache tells it fell back to local cache
+func (c *apolloConsumer) refresh(ctx context.Context, namespace string) (fromCache bool, err error) {
+	c.mu.RLock()
+	releaseKey := ""
+	if current, ok := c.configs[namespace]; ok {
+		releaseKey = current.ReleaseKey
+	}
+	c.mu.RUnlock()
+
+	subPath := fmt.Sprintf("configs/%s/%s/%s?releaseKey=%s",
+		url.PathEscape(c.AppID), url.PathEscape(c.Cluster), url.PathEscape(namespace), url.QueryEscape(releaseKey))
+
+	var latest ApolloNamespaceConfig
+	err = c.get(WithRoute(ctx, "configs/{app_id}/{cluster}/{namespace}"), &c.baseRest, subPath, &latest)
+
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotModified:
+		return false, nil
+	case err != nil:
+		cached, cacheErr := c.loadCache(namespace)
+		if cacheErr != nil {
+			return false, fmt.Errorf("apollo namespace %s unavailable: %s, no local cache: %s", namespace, err, cacheErr)
+		}
+
+		logger.WithError(err).WithField("namespace", namespace).Warn("apollo unreachable, use local cache")
+		latest = *cached
+		fromCache = true
+	default:
+		if cacheErr := c.saveCache(namespace, &latest); cacheErr != nil {
+			logger.WithError(cacheErr).WithField("namespace", namespace).Warn("apollo local cache not saved")
+		}
+	}
+
+	if latest.Configurations == nil {
+		latest.Configurations = make(map[string]string)
+	}
+
+	c.update(namespace, &latest)
+
+	return fromCache, nil
+}
+
+// update replaces namespace configs and notifies subscribers of each changed key
+func (c *apolloConsumer) update(namespace string, latest *ApolloNamespaceConfig) {
+	c.mu.Lock()
+
+	var previous map[string]string
+	if current, ok := c.configs[namespace]; ok {
+		previous = current.Configurations
+	}
+	c.configs[namespace] = latest
+
+	// the first load is not a change
+	var changes []ConfigChange
+	if previous != nil {
+		changes = diffConfigs(namespace, previous, latest.Configurations)
+	}
+
+	subscriptions := make([]apolloSubscription, len(c.subscriptions))
+	copy(subscriptions, c.subscriptions)
+
+	c.mu.Unlock()
+
+	for _, change := range changes {
+		for _, s := range subscriptions {
+			if s.namespace == namespace && (s.key == "" || s.key == change.Key) {
+				s.callback(change)
+			}
+		}
+	}
+}
+
+func diffConfigs(namespace string, previous map[string]string, latest map[string]string) (changes []ConfigChange) {
+	for k, v := range latest {
+		old, ok := previous[k]
+		switch {
+		case !ok:
+			changes = append(changes, ConfigChange{Namespace: namespace, Key: k, NewValue: v, Type: ConfigAdded})
+		case old != v:
+			changes = append(changes, ConfigChange{Namespace: namespace, Key: k, OldValue: old, NewValue: v, Type: ConfigModified})
+		}
+	}
+
+	for k, old := range previous {
+		if _, ok := latest[k]; !ok {
+			changes = append(changes, ConfigChange{Namespace: namespace, Key: k, OldValue: old, Type: ConfigDeleted})
+		}
+	}
+
+	return
+}
+
+// get requests config service through rest, which is the consumer itself or a long polling copy.
+// The response cache is bypassed, a cached notification or config would be polled again and again.
+func (c *apolloConsumer) get(ctx context.Context, rest *baseRest, subPath string, out interface{}) (err error) {
+	ctx = WithCacheTTL(ctx, 0)
+
+	req, err := rest.Request(http.MethodGet, fmt.Sprintf("%s://%s/%s", rest.Scheme, rest.Host, subPath), nil)
+	if err != nil {
+		return
+	}
+
+	return rest.JsonWithReqContext(ctx, req, out)
+}
+
+func (c *apolloConsumer) cacheFile(namespace string) string {
+	return filepath.Join(c.CacheDir, fmt.Sprintf("%s+%s+%s.json", c.AppID, c.Cluster, namespace))
+}
+
+func (c *apolloConsumer) saveCache(namespace string, conf *ApolloNamespaceConfig) error {
+	content, err := json.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(c.CacheDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// write then rename, a crash never leaves a half written cache
+	tmpFile := c.cacheFile(namespace) + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, c.cacheFile(namespace))
+}
+
+func (c *apolloConsumer) loadCache(namespace string) (conf *ApolloNamespaceConfig, err error) {
+	content, err := ioutil.ReadFile(c.cacheFile(namespace))
+	if err != nil {
+		return
+	}
+
+	conf = &ApolloNamespaceConfig{}
+	err = json.Unmarshal(content, conf)
+
+	return
+}
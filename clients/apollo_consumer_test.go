@@ -0,0 +1,167 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConfigService serves /configs of one namespace, failing while down
+type fakeConfigService struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	down    bool
+	release ApolloNamespaceConfig
+	polls   int
+}
+
+func newFakeConfigService(t *testing.T, configurations map[string]string) *fakeConfigService {
+	s := &fakeConfigService{release: ApolloNamespaceConfig{
+		AppID: "app", Cluster: DefaultApolloCluster, NamespaceName: DefaultApolloNamespace,
+		Configurations: configurations, ReleaseKey: "r1",
+	}}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/notifications/v2"):
+			s.polls++
+			_ = json.NewEncoder(w).Encode([]apolloNotification{{NamespaceName: DefaultApolloNamespace, NotificationID: 2}})
+		case s.down:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Query().Get("releaseKey") == s.release.ReleaseKey:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			_ = json.NewEncoder(w).Encode(s.release)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakeConfigService) set(down bool, releaseKey string, configurations map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = down
+	if releaseKey != "" {
+		s.release.ReleaseKey, s.release.Configurations = releaseKey, configurations
+	}
+}
+
+func newTestConsumer(t *testing.T, srv *fakeConfigService) *apolloConsumer {
+	return &apolloConsumer{
+		baseRest: baseRest{
+			Name:   "apollo_config",
+			Scheme: "http",
+			Host:   strings.TrimPrefix(srv.URL, "http://"),
+			Config: baseConfig{Breaker: &breakerConfig{Disabled: true}},
+		},
+		AppID:           "app",
+		Cluster:         DefaultApolloCluster,
+		Namespaces:      []string{DefaultApolloNamespace},
+		CacheDir:        t.TempDir(),
+		configs:         make(map[string]*ApolloNamespaceConfig),
+		notificationIDs: map[string]int64{DefaultApolloNamespace: apolloInitNotificationID},
+	}
+}
+
+func TestApolloConsumerFallsBackToCache(t *testing.T) {
+	srv := newFakeConfigService(t, map[string]string{"k": "v1"})
+	c := newTestConsumer(t, srv)
+
+	ctx := context.Background()
+	if fromCache, err := c.refresh(ctx, DefaultApolloNamespace); err != nil || fromCache {
+		t.Fatalf("refresh fromCache %t err %v", fromCache, err)
+	}
+
+	srv.set(true, "", nil)
+
+	// a new consumer starts from the cache written by the first one
+	restarted := newTestConsumer(t, srv)
+	restarted.CacheDir = c.CacheDir
+
+	pollCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	if err := restarted.Start(pollCtx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := restarted.Get(DefaultApolloNamespace, "k"); v != "v1" {
+		t.Fatalf("cached value %q, want v1", v)
+	}
+}
+
+func TestApolloConsumerKeepsNotificationUntilFetched(t *testing.T) {
+	srv := newFakeConfigService(t, map[string]string{"k": "v1"})
+	c := newTestConsumer(t, srv)
+
+	ctx := context.Background()
+	if _, err := c.refresh(ctx, DefaultApolloNamespace); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []ConfigChange
+	c.Subscribe(DefaultApolloNamespace, "k", func(change ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	changed := []apolloNotification{{NamespaceName: DefaultApolloNamespace, NotificationID: 2}}
+
+	// released while the config service fails, the local cache is served meanwhile
+	srv.set(true, "r2", map[string]string{"k": "v2"})
+	if c.applyNotifications(ctx, changed) {
+		t.Fatal("notification applied from local cache")
+	}
+	if id := c.notificationIDs[DefaultApolloNamespace]; id != apolloInitNotificationID {
+		t.Fatalf("notification id %d advanced without fetching", id)
+	}
+
+	srv.set(false, "", nil)
+	if !c.applyNotifications(ctx, changed) {
+		t.Fatal("notification not applied")
+	}
+	if id := c.notificationIDs[DefaultApolloNamespace]; id != 2 {
+		t.Fatalf("notification id %d, want 2", id)
+	}
+	if v, _ := c.Get(DefaultApolloNamespace, "k"); v != "v2" {
+		t.Fatalf("value %q, want v2", v)
+	}
+	if len(changes) != 1 || changes[0].Type != ConfigModified || changes[0].NewValue != "v2" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+
+	// nothing new is released, config service answers 304
+	if !c.applyNotifications(ctx, []apolloNotification{{NamespaceName: DefaultApolloNamespace, NotificationID: 3}}) {
+		t.Fatal("not modified notification not applied")
+	}
+	if id := c.notificationIDs[DefaultApolloNamespace]; id != 3 {
+		t.Fatalf("notification id %d, want 3", id)
+	}
+}
+
+func TestApolloConsumerBypassesResponseCache(t *testing.T) {
+	srv := newFakeConfigService(t, map[string]string{"k": "v1"})
+	c := newTestConsumer(t, srv)
+	c.Config.CacheTTL = time.Minute
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := c.waitNotifications(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if srv.polls != 2 {
+		t.Fatalf("%d long polls reached config service, want 2", srv.polls)
+	}
+}
@@ -0,0 +1,225 @@
+package clients_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go-cygnus/clients"
+	"go-cygnus/clients/apollotest"
+)
+
+const apolloToken = "token"
+
+var ns = clients.GetNamespaceInfoReq{Env: "DEV", AppID: "app", ClusterName: "default", NamespaceName: "application"}
+
+func newApollo(t *testing.T) (*apollotest.Server, context.Context) {
+	srv := apollotest.NewServer(apolloToken)
+	t.Cleanup(srv.Close)
+
+	srv.AddNamespace(ns.Env, ns.AppID, ns.ClusterName, ns.NamespaceName, map[string]string{"k1": "v1", "k2": "v2"})
+
+	return srv, context.Background()
+}
+
+// assertHTTPError checks err is a *clients.HTTPError of code carrying the apollo message
+func assertHTTPError(t *testing.T, err error, code int) {
+	t.Helper()
+
+	var httpErr *clients.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("err %v, want *clients.HTTPError", err)
+	}
+	if httpErr.StatusCode != code {
+		t.Fatalf("status %d, want %d", httpErr.StatusCode, code)
+	}
+	if httpErr.Message == "" {
+		t.Errorf("no upstream message parsed from %s", httpErr.Body)
+	}
+}
+
+func TestApolloUnauthorized(t *testing.T) {
+	srv, ctx := newApollo(t)
+
+	_, err := clients.NewApollo("http", srv.Hostname(), "wrong").GetNamespaceInfo(ctx, ns)
+	assertHTTPError(t, err, http.StatusUnauthorized)
+}
+
+func TestApolloGetNamespaceInfo(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	info, err := apollo.GetNamespaceInfo(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NamespaceName != ns.NamespaceName || len(info.Items) != 2 || info.Items[0].Key != "k1" {
+		t.Fatalf("unexpected namespace %+v", info)
+	}
+
+	missing := ns
+	missing.NamespaceName = "missing"
+	_, err = apollo.GetNamespaceInfo(ctx, missing)
+	assertHTTPError(t, err, http.StatusNotFound)
+}
+
+func TestApolloGetNamespaceInfos(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	missing := ns
+	missing.NamespaceName = "missing"
+
+	infos, errs := apollo.GetNamespaceInfos(ctx, []clients.GetNamespaceInfoReq{ns, missing})
+	if errs[0] != nil || infos[0].NamespaceName != ns.NamespaceName {
+		t.Fatalf("first namespace %+v, err %v", infos[0], errs[0])
+	}
+	assertHTTPError(t, errs[1], http.StatusNotFound)
+}
+
+func TestApolloClusters(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	envClusters, err := apollo.ListEnvClusters(ctx, ns.AppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envClusters) != 1 || envClusters[0].Env != ns.Env || envClusters[0].Clusters[0] != ns.ClusterName {
+		t.Fatalf("unexpected env clusters %+v", envClusters)
+	}
+
+	cluster, err := apollo.GetCluster(ctx, clients.GetClusterReq{Env: ns.Env, AppID: ns.AppID, ClusterName: ns.ClusterName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Name != ns.ClusterName || cluster.AppID != ns.AppID {
+		t.Fatalf("unexpected cluster %+v", cluster)
+	}
+
+	_, err = apollo.GetCluster(ctx, clients.GetClusterReq{Env: ns.Env, AppID: ns.AppID, ClusterName: "missing"})
+	assertHTTPError(t, err, http.StatusNotFound)
+}
+
+func TestApolloItems(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	created, err := apollo.CreateItem(ctx, clients.CreateItemReq{GetNamespaceInfoReq: ns, Key: "k3", Value: "v3", Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Key != "k3" || created.Value != "v3" || created.DataChangeCreatedBy != "ops" {
+		t.Fatalf("unexpected created item %+v", created)
+	}
+
+	_, err = apollo.CreateItem(ctx, clients.CreateItemReq{GetNamespaceInfoReq: ns, Key: "k3", Value: "v3", Operator: "ops"})
+	assertHTTPError(t, err, http.StatusBadRequest)
+
+	err = apollo.UpdateItem(ctx, clients.UpdateItemReq{GetNamespaceInfoReq: ns, Key: "k3", Value: "v4", Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := apollo.GetItem(ctx, clients.GetItemReq{GetNamespaceInfoReq: ns, Key: "k3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Value != "v4" || item.DataChangeLastModifiedBy != "ops" {
+		t.Fatalf("unexpected updated item %+v", item)
+	}
+
+	err = apollo.UpdateItem(ctx, clients.UpdateItemReq{GetNamespaceInfoReq: ns, Key: "k5", Value: "v5", Operator: "ops"})
+	assertHTTPError(t, err, http.StatusNotFound)
+
+	err = apollo.UpdateItem(ctx, clients.UpdateItemReq{
+		GetNamespaceInfoReq: ns, Key: "k5", Value: "v5", Operator: "ops", CreateIfNotExists: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = apollo.DeleteItem(ctx, clients.DeleteItemReq{GetNamespaceInfoReq: ns, Key: "k5", Operator: "ops"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = apollo.GetItem(ctx, clients.GetItemReq{GetNamespaceInfoReq: ns, Key: "k5"})
+	assertHTTPError(t, err, http.StatusNotFound)
+
+	err = apollo.DeleteItem(ctx, clients.DeleteItemReq{GetNamespaceInfoReq: ns, Key: "k1"})
+	assertHTTPError(t, err, http.StatusBadRequest)
+}
+
+func TestApolloCreateNamespace(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	req := clients.CreateNamespaceReq{AppID: ns.AppID, Name: "db", Format: "properties", Operator: "ops"}
+
+	created, err := apollo.CreateNamespace(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "db" || created.AppID != ns.AppID || created.Format != "properties" {
+		t.Fatalf("unexpected namespace %+v", created)
+	}
+
+	// an app namespace shows up in every cluster of the app
+	dbNS := ns
+	dbNS.NamespaceName = "db"
+	if _, err = apollo.GetNamespaceInfo(ctx, dbNS); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = apollo.CreateNamespace(ctx, req)
+	assertHTTPError(t, err, http.StatusBadRequest)
+}
+
+func TestApolloReleases(t *testing.T) {
+	srv, ctx := newApollo(t)
+	apollo := clients.NewApollo("http", srv.Hostname(), apolloToken)
+
+	first, err := apollo.ReleaseNamespace(ctx, clients.ReleaseNamespaceReq{GetNamespaceInfoReq: ns, Title: "r1", Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || first.Name != "r1" || first.Configurations["k1"] != "v1" {
+		t.Fatalf("unexpected release %+v", first)
+	}
+
+	_, err = apollo.ReleaseNamespace(ctx, clients.ReleaseNamespaceReq{GetNamespaceInfoReq: ns, Operator: "ops"})
+	assertHTTPError(t, err, http.StatusBadRequest)
+
+	second, err := apollo.ReleaseNamespace(ctx, clients.ReleaseNamespaceReq{GetNamespaceInfoReq: ns, Title: "r2", Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := apollo.GetLatestRelease(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != second.ID {
+		t.Fatalf("latest release %d, want %d", latest.ID, second.ID)
+	}
+
+	// only the latest release can be rolled back
+	err = apollo.RollbackRelease(ctx, clients.RollbackReleaseReq{Env: ns.Env, ReleaseID: first.ID, Operator: "ops"})
+	assertHTTPError(t, err, http.StatusBadRequest)
+
+	err = apollo.RollbackRelease(ctx, clients.RollbackReleaseReq{Env: ns.Env, ReleaseID: second.ID, Operator: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latest, err = apollo.GetLatestRelease(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != first.ID {
+		t.Fatalf("latest release %d after rollback, want %d", latest.ID, first.ID)
+	}
+
+	err = apollo.RollbackRelease(ctx, clients.RollbackReleaseReq{Env: ns.Env, ReleaseID: 404, Operator: "ops"})
+	assertHTTPError(t, err, http.StatusNotFound)
+}
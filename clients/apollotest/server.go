@@ -0,0 +1,436 @@
+// Package apollotest provides an in-memory apollo open api server for testing code built on clients.Apollo.
+//
+//	srv := apollotest.NewServer("token")
+//	defer srv.Close()
+//	srv.AddNamespace("DEV", "app", "default", "application", map[string]string{"k": "v"})
+//	apollo := clients.NewApollo("http", srv.Hostname(), srv.Token)
+package apollotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-cygnus/clients"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000+0800"
+
+type namespaceKey struct {
+	Env, AppID, ClusterName, NamespaceName string
+}
+
+type namespace struct {
+	info     clients.ApolloNamespace
+	items    map[string]clients.ApolloItem
+	releases []int64 // release ids, latest last
+}
+
+// Server is a fake apollo portal, only requests carrying Token are accepted
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu            sync.Mutex
+	namespaces    map[namespaceKey]*namespace
+	appNamespaces map[string]map[string]clients.ApolloAppNamespace
+	releases      map[int64]clients.ApolloRelease
+	releaseEnvs   map[int64]namespaceKey
+	lastReleaseID int64
+}
+
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:         token,
+		namespaces:    make(map[namespaceKey]*namespace),
+		appNamespaces: make(map[string]map[string]clients.ApolloAppNamespace),
+		releases:      make(map[int64]clients.ApolloRelease),
+		releaseEnvs:   make(map[int64]namespaceKey),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// Hostname is host:port of the server, as clients.yml hostname
+func (s *Server) Hostname() string {
+	return s.Listener.Addr().String()
+}
+
+// AddNamespace seeds a namespace, creating its env and cluster implicitly
+func (s *Server) AddNamespace(env string, appID string, clusterName string, namespaceName string, items map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := s.ensureNamespace(namespaceKey{env, appID, clusterName, namespaceName})
+	for k, v := range items {
+		ns.items[k] = clients.ApolloItem{Key: k, Value: v, DataChangeCreatedTime: now()}
+	}
+}
+
+func (s *Server) ensureNamespace(key namespaceKey) *namespace {
+	if ns, ok := s.namespaces[key]; ok {
+		return ns
+	}
+
+	ns := &namespace{
+		info: clients.ApolloNamespace{
+			AppID:                 key.AppID,
+			ClusterName:           key.ClusterName,
+			NamespaceName:         key.NamespaceName,
+			Format:                "properties",
+			DataChangeCreatedTime: now(),
+		},
+		items: make(map[string]clients.ApolloItem),
+	}
+	s.namespaces[key] = ns
+
+	return ns
+}
+
+func now() string {
+	return time.Now().Format(timeLayout)
+}
+
+type apolloError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(code)
+
+	if v != nil {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, apolloError{Status: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/openapi/v1"), "/")
+
+	var parts []string
+	for _, p := range strings.Split(path, "/") {
+		unescaped, err := url.PathUnescape(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid path %s", path)
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case match(parts, "apps", "*", "envclusters") && r.Method == http.MethodGet:
+		s.listEnvClusters(w, parts[1])
+	case match(parts, "apps", "*", "appnamespaces") && r.Method == http.MethodPost:
+		s.createNamespace(w, r, parts[1])
+	case match(parts, "envs", "*", "releases", "*", "rollback") && r.Method == http.MethodPut:
+		s.rollback(w, r, parts[1], parts[3])
+	case match(parts, "envs", "*", "apps", "*", "clusters", "*") && r.Method == http.MethodGet:
+		s.getCluster(w, parts[1], parts[3], parts[5])
+	case len(parts) >= 8 && match(parts[:8], "envs", "*", "apps", "*", "clusters", "*", "namespaces", "*"):
+		key := namespaceKey{parts[1], parts[3], parts[5], parts[7]}
+		ns, ok := s.namespaces[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "namespace not found: %s", key.NamespaceName)
+			return
+		}
+		s.serveNamespace(w, r, key, ns, parts[8:])
+	default:
+		writeError(w, http.StatusNotFound, "no handler for %s %s", r.Method, r.URL.Path)
+	}
+}
+
+// match compares path parts with a pattern, * matches any single part
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p != "*" && p != parts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) serveNamespace(w http.ResponseWriter, r *http.Request, key namespaceKey, ns *namespace, rest []string) {
+	switch {
+	case match(rest) && r.Method == http.MethodGet:
+		info := ns.info
+		info.Items = sortedItems(ns.items)
+		writeJSON(w, http.StatusOK, info)
+	case match(rest, "items") && r.Method == http.MethodPost:
+		s.createItem(w, r, ns)
+	case match(rest, "items", "*"):
+		s.serveItem(w, r, ns, rest[1])
+	case match(rest, "releases") && r.Method == http.MethodPost:
+		s.release(w, r, key, ns)
+	case match(rest, "releases", "latest") && r.Method == http.MethodGet:
+		if len(ns.releases) == 0 {
+			writeJSON(w, http.StatusOK, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.releases[ns.releases[len(ns.releases)-1]])
+	default:
+		writeError(w, http.StatusNotFound, "no handler for %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func sortedItems(items map[string]clients.ApolloItem) []clients.ApolloItem {
+	sorted := make([]clients.ApolloItem, 0, len(items))
+	for _, item := range items {
+		sorted = append(sorted, item)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	return sorted
+}
+
+func (s *Server) listEnvClusters(w http.ResponseWriter, appID string) {
+	clusters := make(map[string]map[string]bool)
+	for key := range s.namespaces {
+		if key.AppID != appID {
+			continue
+		}
+		if clusters[key.Env] == nil {
+			clusters[key.Env] = make(map[string]bool)
+		}
+		clusters[key.Env][key.ClusterName] = true
+	}
+
+	rsp := make([]clients.ApolloEnvClusters, 0, len(clusters))
+	for env, names := range clusters {
+		envClusters := clients.ApolloEnvClusters{Env: env}
+		for name := range names {
+			envClusters.Clusters = append(envClusters.Clusters, name)
+		}
+		sort.Strings(envClusters.Clusters)
+		rsp = append(rsp, envClusters)
+	}
+
+	sort.Slice(rsp, func(i, j int) bool {
+		return rsp[i].Env < rsp[j].Env
+	})
+
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+func (s *Server) getCluster(w http.ResponseWriter, env string, appID string, clusterName string) {
+	for key := range s.namespaces {
+		if key.Env == env && key.AppID == appID && key.ClusterName == clusterName {
+			writeJSON(w, http.StatusOK, clients.ApolloCluster{Name: clusterName, AppID: appID})
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "cluster not found: %s", clusterName)
+}
+
+func (s *Server) createNamespace(w http.ResponseWriter, r *http.Request, appID string) {
+	var req clients.ApolloAppNamespace
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+
+	if req.Name == "" || req.DataChangeCreatedBy == "" {
+		writeError(w, http.StatusBadRequest, "name and dataChangeCreatedBy are required")
+		return
+	}
+
+	if _, ok := s.appNamespaces[appID][req.Name]; ok {
+		writeError(w, http.StatusBadRequest, "namespace %s already exists", req.Name)
+		return
+	}
+
+	if s.appNamespaces[appID] == nil {
+		s.appNamespaces[appID] = make(map[string]clients.ApolloAppNamespace)
+	}
+	req.AppID = appID
+	s.appNamespaces[appID][req.Name] = req
+
+	// like apollo, an app namespace shows up in every cluster of the app
+	var clusters []namespaceKey
+	for key := range s.namespaces {
+		if key.AppID == appID {
+			clusters = append(clusters, key)
+		}
+	}
+
+	for _, key := range clusters {
+		ns := s.ensureNamespace(namespaceKey{key.Env, appID, key.ClusterName, req.Name})
+		ns.info.Format = req.Format
+		ns.info.IsPublic = req.IsPublic
+		ns.info.Comment = req.Comment
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (s *Server) createItem(w http.ResponseWriter, r *http.Request, ns *namespace) {
+	var req clients.ApolloItem
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+
+	if req.Key == "" || req.DataChangeCreatedBy == "" {
+		writeError(w, http.StatusBadRequest, "key and dataChangeCreatedBy are required")
+		return
+	}
+
+	if _, ok := ns.items[req.Key]; ok {
+		writeError(w, http.StatusBadRequest, "item already exists")
+		return
+	}
+
+	req.DataChangeCreatedTime = now()
+	req.DataChangeLastModifiedBy = req.DataChangeCreatedBy
+	req.DataChangeLastModifiedTime = req.DataChangeCreatedTime
+	ns.items[req.Key] = req
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (s *Server) serveItem(w http.ResponseWriter, r *http.Request, ns *namespace, key string) {
+	item, exists := ns.items[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			writeError(w, http.StatusNotFound, "item not found for %s", key)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	case http.MethodPut:
+		var req clients.ApolloItem
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+			return
+		}
+
+		if !exists {
+			if r.URL.Query().Get("createIfNotExists") != "true" {
+				writeError(w, http.StatusNotFound, "item not found for %s", key)
+				return
+			}
+			item = clients.ApolloItem{Key: key, DataChangeCreatedBy: req.DataChangeCreatedBy, DataChangeCreatedTime: now()}
+		}
+
+		item.Value = req.Value
+		item.Comment = req.Comment
+		item.DataChangeLastModifiedBy = req.DataChangeLastModifiedBy
+		item.DataChangeLastModifiedTime = now()
+		ns.items[key] = item
+
+		writeJSON(w, http.StatusOK, nil)
+	case http.MethodDelete:
+		if !exists {
+			writeError(w, http.StatusNotFound, "item not found for %s", key)
+			return
+		}
+		if r.URL.Query().Get("operator") == "" {
+			writeError(w, http.StatusBadRequest, "operator is required")
+			return
+		}
+
+		delete(ns.items, key)
+		writeJSON(w, http.StatusOK, nil)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func (s *Server) release(w http.ResponseWriter, r *http.Request, key namespaceKey, ns *namespace) {
+	var req struct {
+		ReleaseTitle   string `json:"releaseTitle"`
+		ReleaseComment string `json:"releaseComment"`
+		ReleasedBy     string `json:"releasedBy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+
+	if req.ReleaseTitle == "" || req.ReleasedBy == "" {
+		writeError(w, http.StatusBadRequest, "releaseTitle and releasedBy are required")
+		return
+	}
+
+	configurations := make(map[string]string, len(ns.items))
+	for k, item := range ns.items {
+		configurations[k] = item.Value
+	}
+
+	s.lastReleaseID++
+	release := clients.ApolloRelease{
+		ID:                    s.lastReleaseID,
+		AppID:                 key.AppID,
+		ClusterName:           key.ClusterName,
+		NamespaceName:         key.NamespaceName,
+		Name:                  req.ReleaseTitle,
+		Configurations:        configurations,
+		Comment:               req.ReleaseComment,
+		DataChangeCreatedBy:   req.ReleasedBy,
+		DataChangeCreatedTime: now(),
+	}
+	s.releases[release.ID] = release
+	s.releaseEnvs[release.ID] = key
+	ns.releases = append(ns.releases, release.ID)
+
+	writeJSON(w, http.StatusOK, release)
+}
+
+func (s *Server) rollback(w http.ResponseWriter, r *http.Request, env string, releaseID string) {
+	id, err := strconv.ParseInt(releaseID, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid release id %s", releaseID)
+		return
+	}
+
+	if r.URL.Query().Get("operator") == "" {
+		writeError(w, http.StatusBadRequest, "operator is required")
+		return
+	}
+
+	key, ok := s.releaseEnvs[id]
+	if !ok || key.Env != env {
+		writeError(w, http.StatusNotFound, "release not found: %d", id)
+		return
+	}
+
+	// only the latest release can be rolled back, like apollo does
+	ns := s.namespaces[key]
+	if len(ns.releases) == 0 || ns.releases[len(ns.releases)-1] != id {
+		writeError(w, http.StatusBadRequest, "release %d is not the latest one", id)
+		return
+	}
+
+	ns.releases = ns.releases[:len(ns.releases)-1]
+	writeJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,359 @@
+package clients
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator signs an outbound request, it is called before every attempt of baseRest.Do
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// TokenInvalidator is an Authenticator caching a token, baseRest drops the token a request was signed with
+// once the upstream answers 401 to it, so the next attempt fetches a new one
+type TokenInvalidator interface {
+	InvalidateToken(req *http.Request)
+}
+
+// TokenAuth sets a static token, as "Authorization: <Prefix> <Token>" by default
+type TokenAuth struct {
+	Header string
+	Prefix string // e.g. Bearer
+	Token  string
+}
+
+func (a *TokenAuth) Authenticate(req *http.Request) error {
+	header := a.Header
+	if header == "" {
+		header = "Authorization"
+	}
+
+	value := a.Token
+	if a.Prefix != "" {
+		value = a.Prefix + " " + a.Token
+	}
+
+	req.Header.Set(header, value)
+
+	return nil
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// HMACAuth signs "<method>\n<path with query>\n<timestamp>\n<hex sha256 of body>" with hmac-sha256,
+// sent as "Authorization: HMAC-SHA256 KeyId=<KeyID>, Signature=<base64>" and "X-Timestamp: <unix ms>"
+type HMACAuth struct {
+	KeyID  string
+	Secret string
+}
+
+func (a *HMACAuth) Authenticate(req *http.Request) error {
+	body, err := peekBody(req)
+	if err != nil {
+		return err
+	}
+
+	bodyHash := sha256.Sum256(body)
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+
+	mac := hmac.New(sha256.New, []byte(a.Secret))
+	mac.Write([]byte(strings.Join([]string{
+		req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 KeyId=%s, Signature=%s",
+		a.KeyID, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+
+	return nil
+}
+
+// peekBody reads body through GetBody, leaving req.Body unread
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// ApolloAuth is the access key signature of apollo config service
+type ApolloAuth struct {
+	AppID  string
+	Secret string
+}
+
+func (a *ApolloAuth) Authenticate(req *http.Request) error {
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+
+	// base64(hmac-sha1(timestamp + "\n" + path with query))
+	mac := hmac.New(sha1.New, []byte(a.Secret))
+	mac.Write([]byte(timestamp + "\n" + req.URL.RequestURI()))
+
+	req.Header.Set("Timestamp", timestamp)
+	req.Header.Set("Authorization", fmt.Sprintf("Apollo %s:%s", a.AppID, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+
+	return nil
+}
+
+// oauth2ExpirySkew refreshes a token a bit before it really expires
+const oauth2ExpirySkew = 30 * time.Second
+
+// OAuth2ClientCredentials fetches a bearer token by client credentials grant and caches it until expiry
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Client sends token requests, the one of the endpoint for its TLS and proxy, sharedClient if not set
+	Client *http.Client
+
+	mu         sync.Mutex
+	token      string
+	expires    time.Time
+	refreshing *oauth2Refresh // nil unless a token request is in flight
+}
+
+// oauth2Refresh is a token request in flight, done is closed once it ends with err
+type oauth2Refresh struct {
+	done chan struct{}
+	err  error
+}
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (a *OAuth2ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := a.currentToken(req)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// InvalidateToken drops the cached token if req was signed with it
+func (a *OAuth2ClientCredentials) InvalidateToken(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && req.Header.Get("Authorization") == "Bearer "+a.token {
+		a.token = ""
+	}
+}
+
+// currentToken returns the cached token or fetches a new one, concurrent callers wait for the same
+// token request without holding the lock
+func (a *OAuth2ClientCredentials) currentToken(req *http.Request) (token string, err error) {
+	a.mu.Lock()
+
+	if a.token != "" && time.Now().Before(a.expires) {
+		token = a.token
+		a.mu.Unlock()
+		return
+	}
+
+	if refresh := a.refreshing; refresh != nil {
+		a.mu.Unlock()
+
+		select {
+		case <-refresh.done:
+		case <-req.Context().Done():
+			return "", req.Context().Err()
+		}
+
+		if refresh.err != nil {
+			return "", refresh.err
+		}
+
+		a.mu.Lock()
+		token = a.token
+		a.mu.Unlock()
+		return
+	}
+
+	refresh := &oauth2Refresh{done: make(chan struct{})}
+	a.refreshing = refresh
+	a.mu.Unlock()
+
+	var expires time.Time
+	token, expires, err = a.fetch(req)
+
+	a.mu.Lock()
+	if err == nil {
+		a.token, a.expires = token, expires
+	}
+	a.refreshing = nil
+	a.mu.Unlock()
+
+	refresh.err = err
+	close(refresh.done)
+
+	return
+}
+
+func (a *OAuth2ClientCredentials) fetch(origin *http.Request) (accessToken string, expires time.Time, err error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(origin.Context(), http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	client := a.Client
+	if client == nil {
+		client = sharedClient
+	}
+
+	rsp, err := withCassette(client).Do(req)
+	if err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	rspData, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return
+	}
+
+	if rsp.StatusCode > 299 || rsp.StatusCode < 200 {
+		err = newHTTPError(req.Method, a.TokenURL, rsp.StatusCode, rspData)
+		return
+	}
+
+	var token oauth2Token
+	if err = json.Unmarshal(rspData, &token); err != nil {
+		return
+	}
+
+	if token.AccessToken == "" {
+		err = fmt.Errorf("no access_token from %s", a.TokenURL)
+		return
+	}
+
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	if lifetime <= oauth2ExpirySkew {
+		// expires_in is optional, cache such a token for a short while only
+		lifetime = 2 * oauth2ExpirySkew
+	}
+
+	return token.AccessToken, time.Now().Add(lifetime - oauth2ExpirySkew), nil
+}
+
+// authConfig is the clients.yml form of an Authenticator
+type authConfig struct {
+	Type string `yaml:"type" validate:"required,oneof=token basic hmac oauth2 apollo"`
+
+	// token
+	Header string `yaml:"header"`
+	Prefix string `yaml:"prefix"`
+	Token  string `yaml:"token"`
+
+	// basic
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// hmac and apollo, KeyID is the app id of apollo
+	KeyID  string `yaml:"key_id"`
+	Secret string `yaml:"secret"`
+
+	// oauth2 client credentials
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// NewAuthenticator builds an Authenticator from clients.yml, nil config means no auth.
+// client is the http client of the endpoint, nil for sharedClient.
+func (c *authConfig) NewAuthenticator(client *http.Client) (Authenticator, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	required := func(fields map[string]string) error {
+		for name, value := range fields {
+			if value == "" {
+				return fmt.Errorf("%s auth requires %s", c.Type, name)
+			}
+		}
+
+		return nil
+	}
+
+	switch c.Type {
+	case "token":
+		if err := required(map[string]string{"token": c.Token}); err != nil {
+			return nil, err
+		}
+		return &TokenAuth{Header: c.Header, Prefix: c.Prefix, Token: c.Token}, nil
+	case "basic":
+		if err := required(map[string]string{"username": c.Username}); err != nil {
+			return nil, err
+		}
+		return &BasicAuth{Username: c.Username, Password: c.Password}, nil
+	case "hmac":
+		if err := required(map[string]string{"key_id": c.KeyID, "secret": c.Secret}); err != nil {
+			return nil, err
+		}
+		return &HMACAuth{KeyID: c.KeyID, Secret: c.Secret}, nil
+	case "apollo":
+		if err := required(map[string]string{"key_id": c.KeyID, "secret": c.Secret}); err != nil {
+			return nil, err
+		}
+		return &ApolloAuth{AppID: c.KeyID, Secret: c.Secret}, nil
+	case "oauth2":
+		if err := required(map[string]string{"token_url": c.TokenURL, "client_id": c.ClientID}); err != nil {
+			return nil, err
+		}
+		return &OAuth2ClientCredentials{
+			TokenURL:     c.TokenURL,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Scopes:       c.Scopes,
+			Client:       client,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown auth type %q", c.Type)
+}
@@ -0,0 +1,242 @@
+package clients
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOAuth2UsesEndpointTransport(t *testing.T) {
+	// only trusted through the ca_file of the endpoint
+	tokenSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, _, _ := r.BasicAuth(); id != "cid" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"t1","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenSrv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, clients, err := load([]byte(fmt.Sprintf(`
+endpoints:
+  secured:
+    scheme: https
+    hostname: api.example.com
+    auth: {type: oauth2, token_url: %s/token, client_id: cid, client_secret: secret}
+    transport:
+      tls: {ca_file: %s}
+`, tokenSrv.URL, caFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/x", nil)
+	if err = clients["secured"].Auth.Authenticate(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("Authorization"); got != "Bearer t1" {
+		t.Fatalf("Authorization %q, want Bearer t1", got)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/x", nil)
+
+	_ = (&TokenAuth{Token: "t1"}).Authenticate(req)
+	if got := req.Header.Get("Authorization"); got != "t1" {
+		t.Errorf("Authorization %q, want t1", got)
+	}
+
+	_ = (&TokenAuth{Header: "X-Api-Key", Prefix: "Key", Token: "t2"}).Authenticate(req)
+	if got := req.Header.Get("X-Api-Key"); got != "Key t2" {
+		t.Errorf("X-Api-Key %q, want Key t2", got)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/x", nil)
+
+	_ = (&BasicAuth{Username: "u", Password: "p"}).Authenticate(req)
+	if username, password, ok := req.BasicAuth(); !ok || username != "u" || password != "p" {
+		t.Fatalf("basic auth %q %q, want u p", username, password)
+	}
+}
+
+// verifyHMAC checks the signature of HMACAuth the way an upstream would
+func verifyHMAC(r *http.Request, body []byte, keyID, secret string) error {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{
+		r.Method, r.URL.RequestURI(), r.Header.Get("X-Timestamp"), hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+
+	want := fmt.Sprintf("HMAC-SHA256 KeyId=%s, Signature=%s", keyID, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	if got := r.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("Authorization %q, want %q", got, want)
+	}
+
+	return nil
+}
+
+func TestHMACAuthSignsBodyOfEveryAttempt(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := verifyHMAC(r, body, "k1", "s1"); err != nil || len(body) == 0 {
+			t.Errorf("attempt %d with body %q: %v", atomic.LoadInt32(&attempts)+1, body, err)
+		}
+
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	rest := retryingRest(srv, RetryPolicy{})
+	rest.Auth = &HMACAuth{KeyID: "k1", Secret: "s1"}
+
+	payload := map[string]string{"key": "value"}
+	if err := rest.JsonWithContext(context.Background(), http.MethodPut, "items?x=1", &payload, nil); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("%d attempts, want 2", attempts)
+	}
+}
+
+func TestApolloAuth(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://apollo.example.com/configs/app/default/application?ip=1", nil)
+
+	if err := (&ApolloAuth{AppID: "app", Secret: "s1"}).Authenticate(req); err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha1.New, []byte("s1"))
+	mac.Write([]byte(req.Header.Get("Timestamp") + "\n/configs/app/default/application?ip=1"))
+
+	want := "Apollo app:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get("Authorization"); req.Header.Get("Timestamp") == "" || got != want {
+		t.Fatalf("Authorization %q, want %q", got, want)
+	}
+}
+
+// tokenServer issues t1, t2... counting the token requests, a token request waits for release if not nil
+func tokenServer(t *testing.T, release chan struct{}) (srv *httptest.Server, issued *int32) {
+	issued = new(int32)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if release != nil {
+			<-release
+		}
+		_, _ = fmt.Fprintf(w, `{"access_token":"t%d","expires_in":3600}`, atomic.AddInt32(issued, 1))
+	}))
+	t.Cleanup(srv.Close)
+
+	return
+}
+
+func TestOAuth2DropsTokenOn401(t *testing.T) {
+	tokenSrv, issued := tokenServer(t, nil)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+
+	rest := &baseRest{
+		Name:   "oauth2",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(api.URL, "http://"),
+		Config: baseConfig{Breaker: &breakerConfig{Disabled: true}},
+		Auth:   &OAuth2ClientCredentials{TokenURL: tokenSrv.URL, ClientID: "cid"},
+	}
+
+	ctx := context.Background()
+	if err := rest.JsonWithContext(ctx, http.MethodGet, "x", nil, nil); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+	if err := rest.JsonWithContext(ctx, http.MethodGet, "x", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *issued != 2 {
+		t.Fatalf("%d tokens issued, want 2", *issued)
+	}
+}
+
+func TestOAuth2FetchesOnceWithoutBlocking(t *testing.T) {
+	release := make(chan struct{})
+	tokenSrv, issued := tokenServer(t, release)
+	auth := &OAuth2ClientCredentials{TokenURL: tokenSrv.URL, ClientID: "cid"}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com/x", nil)
+			if err := auth.Authenticate(req); err != nil {
+				t.Error(err)
+			}
+			tokens[i] = req.Header.Get("Authorization")
+		}(i)
+	}
+
+	// a caller giving up is not held back by the token request in flight
+	for {
+		auth.mu.Lock()
+		inFlight := auth.refreshing != nil
+		auth.mu.Unlock()
+		if inFlight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/x", nil)
+	if err := auth.Authenticate(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v, want deadline exceeded", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for _, token := range tokens {
+		if token != "Bearer t1" {
+			t.Fatalf("tokens %q, want Bearer t1 for all", tokens)
+		}
+	}
+	if *issued != 1 {
+		t.Fatalf("%d tokens issued, want 1", *issued)
+	}
+}
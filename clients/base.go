@@ -2,18 +2,18 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-cygnus/constants"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-
-	"gopkg.in/go-playground/validator.v9"
-	"gopkg.in/yaml.v3"
 )
 
 var logger *logrus.Entry = logrus.New().WithField("logger", "captain-client")
@@ -23,32 +23,48 @@ type restEndpoint struct {
 	Hostname string `yaml:"hostname" validate:"required"`
 }
 
-type restEndpointWithToken struct {
-	Scheme   string `yaml:"scheme" validate:"required"`
-	Hostname string `yaml:"hostname" validate:"required"`
-	Token    string `yaml:"token" validate:"required"`
+type clientsConfig struct {
+	// CircuitBreaker is the default of every endpoint
+	CircuitBreaker *breakerConfig `yaml:"circuit_breaker"`
+	// Endpoints are the upstreams served by Get, each name is a client
+	Endpoints map[string]*endpointConfig `yaml:"endpoints"`
+	// ApolloConsumerConfig is the apollo config service consumer, built on one of Endpoints
+	ApolloConsumerConfig *apolloConsumerConfig `yaml:"apollo_config"`
+	// CacheSize is the capacity of the in-memory response cache, DefaultCacheSize if not set
+	CacheSize int `yaml:"cache_size" validate:"min=0"`
+	// Cassette records or replays every client call, for tests only
+	Cassette *cassetteConfig `yaml:"cassette"`
+	// LegacyApollo is the former top level apollo key, read as endpoints.apollo
+	LegacyApollo *endpointConfig `yaml:"apollo" validate:"-"`
 }
 
-type clientsConfig struct {
-	ApolloConfig *restEndpointWithToken `yaml:"apollo"`
+// breakerConfig of an endpoint, falls back to the global one
+func (c *clientsConfig) breakerConfig(endpoint *breakerConfig) *breakerConfig {
+	if endpoint != nil {
+		return endpoint
+	}
+
+	return c.CircuitBreaker
 }
 
 // remote service url
 type baseConfig struct {
-	Timeout    int
+	Timeout    int // seconds of a whole call including retries
 	RetryTimes int
+	Retry      RetryPolicy
+	Breaker    *breakerConfig // nil uses breaker defaults
 	Trace      bool
 	Headers    map[string]string
+	CacheTTL   time.Duration // GET responses are cached if set
 }
 
 const DefaultReqTimeSecond = 60
 
 var (
-	sharedClient = &http.Client{
-		Timeout: DefaultReqTimeSecond * time.Second,
-	}
+	// sharedClient serves clients without transport config, timeout is applied per call through context,
+	// see baseRest.DoWithContext
+	sharedClient = &http.Client{Transport: newDefaultTransport()}
 
-	// TODO: not used, remove or add into http client hook
 	defaultHTTPConfig = baseConfig{
 		Timeout:    DefaultReqTimeSecond,
 		RetryTimes: 0,
@@ -58,23 +74,6 @@ var (
 	RestConfigs clientsConfig
 )
 
-func init() {
-	// increase default http client connection pool
-	defaultRoundTripper := http.DefaultTransport
-
-	defaultTransportPointer, ok := defaultRoundTripper.(*http.Transport)
-	if !ok {
-		panic(fmt.Sprintf("defaultRoundTripper not an *http.Transport"))
-	}
-	// copy it
-	// dereference it to get a copy of the struct that the pointer points to
-	copyTransportPointer := defaultTransportPointer
-	copyTransportPointer.MaxIdleConns = 100
-	copyTransportPointer.MaxIdleConnsPerHost = 100
-
-	sharedClient.Transport = copyTransportPointer
-}
-
 func Init() {
 	clientsYmlFile := fmt.Sprintf("%s/clients.yml", constants.ConfigPath)
 
@@ -83,21 +82,33 @@ func Init() {
 		panic("Read clients.yml error.")
 	}
 
-	if unmarshalErr := yaml.Unmarshal(clientsContent, &RestConfigs); unmarshalErr != nil {
-		panic(fmt.Sprintf("Unmarshal restclient content error: %s", unmarshalErr))
+	conf, clients, err := load(clientsContent)
+	if err != nil {
+		panic(err.Error())
 	}
 
-	validate := validator.New()
-	if err := validate.Struct(&RestConfigs); err != nil {
-		panic(fmt.Sprintf("invalid restclient config: %s", err))
+	RestConfigs = conf
+	for name, rest := range clients {
+		Register(name, rest)
 	}
+
+	if RestConfigs.CacheSize > 0 {
+		SetCacheStore(NewLRUCache(RestConfigs.CacheSize))
+	}
+
+	initCassette(RestConfigs.Cassette)
 }
 
 type baseRest struct {
+	Name    string // endpoint name in clients.yml
 	Scheme  string
 	Host    string
 	URIBase string
 	Config  baseConfig
+	Auth    Authenticator // optional
+
+	limiter *rateLimiter // optional, shared by copies of the client
+	client  *http.Client // optional, sharedClient if not set
 }
 
 func (b *baseRest) Request(method string, url string, payload interface{}) (req *http.Request, err error) {
@@ -126,38 +137,213 @@ func (b *baseRest) Request(method string, url string, payload interface{}) (req
 }
 
 func (b *baseRest) Do(req *http.Request) (rspData []byte, err error) {
+	return b.DoWithContext(req.Context(), req)
+}
+
+// DoWithContext sends req bound to ctx, the call is canceled once ctx is done or Config.Timeout elapses
+func (b *baseRest) DoWithContext(ctx context.Context, req *http.Request) (rspData []byte, err error) {
 	startTime := time.Now()
-	reqBody, _ := ioutil.ReadAll(req.Body)
-	req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout())
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	propagate(ctx, req)
+
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, _ = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+	}
 
 	l := logger.WithField("url", req.URL.String()).WithField("req_body", string(reqBody))
 
+	cacheTTL := b.cacheTTL(ctx, req)
+	cacheKey := b.cacheKey(req)
+
+	var cached *CacheEntry
+	if cacheTTL > 0 {
+		cached, _ = cacheStore().Get(cacheKey)
+
+		switch {
+		case cached != nil && cached.Fresh():
+			b.countCacheLookup("hit")
+			l.WithField("cache", "hit").Debug("served from cache")
+			return cached.Body, nil
+		case cached != nil && cached.ETag != "":
+			// never touch the header of the caller
+			req.Header = req.Header.Clone()
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+
+	var (
+		httpCode  int
+		rspHeader http.Header
+		attempt   int
+	)
+
 	defer func() {
 		if err != nil {
 			l = l.WithError(err)
 		}
+		l = l.WithField("http_code", httpCode).WithField("attempts", attempt+1)
 		l = l.WithField("cost", time.Now().Sub(startTime).Seconds())
 		l.Debug(time.Now().Sub(startTime).String())
+
+		b.observe(ctx, req.Method, httpCode, err, time.Now().Sub(startTime))
 	}()
 
-	// TODO: can use b.Config.Trace to trace finer http lifecycle
+	waitRateLimit := func() error {
+		if b.limiter == nil {
+			return nil
+		}
+
+		wait, limitErr := b.limiter.Wait(ctx)
+		l = l.WithField("rate_limit_wait", wait.Seconds())
+
+		return limitErr
+	}
+
+	// take the quota ahead of the breaker, calls rejected on this side say nothing of upstream health
+	if err = waitRateLimit(); err != nil {
+		return
+	}
+
+	var (
+		sent         bool // an attempt reached upstream
+		upstreamCode int
+		upstreamErr  error
+	)
+
+	if b.Config.Breaker == nil || !b.Config.Breaker.Disabled {
+		cb := breakerFor(req.URL.Host, b.Config.Breaker)
+		var admitted admission
+		if admitted, err = cb.allow(); err != nil {
+			return
+		}
+
+		defer func() {
+			if !sent {
+				cb.release(admitted)
+				return
+			}
+
+			cb.record(admitted, isBreakerFailure(upstreamCode, upstreamErr))
+		}()
+	}
+
+	if cacheTTL > 0 {
+		defer func() {
+			result := "miss"
+
+			var httpErr *HTTPError
+			switch {
+			case cached != nil && errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotModified:
+				result = "revalidated"
+				rspData, err = cached.Body, nil
+				cacheStore().Set(cacheKey, &CacheEntry{Body: cached.Body, ETag: cached.ETag, Expires: time.Now().Add(cacheTTL)})
+			case err == nil && rspHeader.Get("Vary") != "*":
+				// other Vary headers are part of cacheKey
+				cacheStore().Set(cacheKey, &CacheEntry{Body: rspData, ETag: rspHeader.Get("ETag"), Expires: time.Now().Add(cacheTTL)})
+			}
+
+			b.countCacheLookup(result)
+			l = l.WithField("cache", result)
+		}()
+	}
+
+	for ; ; attempt++ {
+		if attempt > 0 {
+			backoff := b.Config.Retry.Backoff(attempt)
+			l.WithError(err).WithField("backoff", backoff.String()).Debugf("retry %d/%d", attempt, b.Config.RetryTimes)
+
+			select {
+			case <-ctx.Done():
+				// keep the last upstream error so callers can still inspect it
+				l = l.WithField("give_up", ctx.Err().Error())
+				return
+			case <-time.After(backoff):
+			}
+
+			// same as above, keep the last upstream error
+			if limitErr := waitRateLimit(); limitErr != nil {
+				l = l.WithField("give_up", limitErr.Error())
+				return
+			}
+		}
+
+		// replay the buffered body on every attempt
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(reqBody)), nil
+		}
+
+		// sign again, signatures may expire while backing off
+		if b.Auth != nil {
+			if err = b.Auth.Authenticate(req); err != nil {
+				return
+			}
+		}
+
+		attemptReq := req
+		var tracer *requestTrace
+		if b.Config.Trace {
+			tracer = newRequestTrace()
+			attemptReq = req.WithContext(httptrace.WithClientTrace(ctx, tracer.clientTrace()))
+		}
+
+		var retryable bool
+		httpCode, rspHeader, rspData, retryable, err = b.roundTrip(attemptReq)
+		sent, upstreamCode, upstreamErr = true, httpCode, err
+
+		if invalidator, ok := b.Auth.(TokenInvalidator); ok && httpCode == http.StatusUnauthorized {
+			invalidator.InvalidateToken(req)
+		}
+
+		if tracer != nil {
+			l = l.WithFields(tracer.fields())
+		}
+
+		if err == nil || !retryable || attempt >= b.Config.RetryTimes || ctx.Err() != nil ||
+			!b.Config.Retry.ShouldRetryRequest(req) || noRetry(ctx) {
+			return
+		}
+	}
+}
+
+func (b *baseRest) timeout() time.Duration {
+	if b.Config.Timeout > 0 {
+		return time.Duration(b.Config.Timeout) * time.Second
+	}
+
+	return DefaultReqTimeSecond * time.Second
+}
+
+// roundTrip sends req once, retryable tells whether the failure is worth another attempt
+func (b *baseRest) roundTrip(req *http.Request) (
+	httpCode int, rspHeader http.Header, rspData []byte, retryable bool, err error) {
 	var rsp *http.Response
-	if rsp, err = sharedClient.Do(req); err != nil {
+	if rsp, err = b.httpClient().Do(req); err != nil {
+		retryable = b.Config.Retry.ShouldRetryError(err)
 		return
 	}
 
 	defer func() {
 		_ = rsp.Body.Close()
-		l = l.WithField("http_code", rsp.StatusCode)
 	}()
 
+	httpCode = rsp.StatusCode
+	rspHeader = rsp.Header
+
 	if rspData, err = ioutil.ReadAll(rsp.Body); err != nil {
+		retryable = b.Config.Retry.ShouldRetryError(err)
 		return
 	}
 
 	if rsp.StatusCode > 299 || rsp.StatusCode < 200 {
-		err = errors.New(
-			fmt.Sprintf("Invalid http %d when rest %s: %s", rsp.StatusCode, req.URL.String(), string(rspData)))
+		err = newHTTPError(req.Method, req.URL.String(), rsp.StatusCode, rspData)
+		retryable = b.Config.Retry.ShouldRetryStatus(rsp.StatusCode)
 
 		return
 	}
@@ -165,8 +351,20 @@ func (b *baseRest) Do(req *http.Request) (rspData []byte, err error) {
 	return
 }
 
+func (b *baseRest) httpClient() *http.Client {
+	if b.client != nil {
+		return withCassette(b.client)
+	}
+
+	return withCassette(sharedClient)
+}
+
 func (b *baseRest) JsonWithReq(req *http.Request, out interface{}) (err error) {
-	rspData, err := b.Do(req)
+	return b.JsonWithReqContext(req.Context(), req, out)
+}
+
+func (b *baseRest) JsonWithReqContext(ctx context.Context, req *http.Request, out interface{}) (err error) {
+	rspData, err := b.DoWithContext(ctx, req)
 	if err != nil {
 		return
 	}
@@ -179,6 +377,11 @@ func (b *baseRest) JsonWithReq(req *http.Request, out interface{}) (err error) {
 }
 
 func (b *baseRest) Json(method string, subPath string, payload interface{}, out interface{}) (err error) {
+	return b.JsonWithContext(context.Background(), method, subPath, payload, out)
+}
+
+func (b *baseRest) JsonWithContext(
+	ctx context.Context, method string, subPath string, payload interface{}, out interface{}) (err error) {
 	url := fmt.Sprintf("%s://%s/%s/%s", b.Scheme, b.Host, b.URIBase, subPath)
 
 	req, err := b.Request(method, url, payload)
@@ -186,7 +389,7 @@ func (b *baseRest) Json(method string, subPath string, payload interface{}, out
 		return
 	}
 
-	return b.JsonWithReq(req, out)
+	return b.JsonWithReqContext(ctx, req, out)
 }
 
 func SetLogger(l *logrus.Entry) {
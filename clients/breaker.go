@@ -0,0 +1,221 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerOpenSecond       = 30
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerConfig is the clients.yml form of a circuit breaker, zero fields fall back to defaults
+type breakerConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// FailureThreshold consecutive failures trip the breaker open
+	FailureThreshold int `yaml:"failure_threshold" validate:"min=0"`
+	// OpenSeconds is how long the breaker fails fast before letting probes through
+	OpenSeconds int `yaml:"open_seconds" validate:"min=0"`
+	// HalfOpenRequests successful probes close the breaker again
+	HalfOpenRequests int `yaml:"half_open_requests" validate:"min=0"`
+}
+
+func (c *breakerConfig) withDefaults() breakerConfig {
+	conf := breakerConfig{}
+	if c != nil {
+		conf = *c
+	}
+
+	if conf.FailureThreshold == 0 {
+		conf.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if conf.OpenSeconds == 0 {
+		conf.OpenSeconds = DefaultBreakerOpenSecond
+	}
+	if conf.HalfOpenRequests == 0 {
+		conf.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+
+	return conf
+}
+
+// circuitBreaker guards a single upstream host
+type circuitBreaker struct {
+	host string
+	conf breakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	probing   int
+	openedAt  time.Time
+	// halfOpens counts half-open periods, outcomes of probes of a former one are ignored
+	halfOpens int
+}
+
+// admission of a call by allow, record needs it to tell probes from calls admitted while closed
+type admission struct {
+	probe    bool
+	halfOpen int
+}
+
+var (
+	breakers     = make(map[string]*circuitBreaker)
+	breakersLock sync.Mutex
+)
+
+// breakerFor returns the breaker shared by every client of host, conf only counts on first use
+func breakerFor(host string, conf *breakerConfig) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	if cb, ok := breakers[host]; ok {
+		return cb
+	}
+
+	cb := &circuitBreaker{host: host, conf: conf.withDefaults()}
+	breakers[host] = cb
+
+	return cb
+}
+
+// BreakerStateOf reports the breaker state of host, closed if no call has been made yet
+func BreakerStateOf(host string) BreakerState {
+	breakersLock.Lock()
+	cb, ok := breakers[host]
+	breakersLock.Unlock()
+
+	if !ok {
+		return BreakerClosed
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state
+}
+
+func (cb *circuitBreaker) openDuration() time.Duration {
+	return time.Duration(cb.conf.OpenSeconds) * time.Second
+}
+
+// allow returns CircuitOpenError if the call must fail fast
+func (cb *circuitBreaker) allow() (a admission, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen {
+		if wait := cb.openDuration() - time.Since(cb.openedAt); wait > 0 {
+			return a, &CircuitOpenError{Host: cb.host, RetryAfter: wait}
+		}
+
+		cb.state = BreakerHalfOpen
+		cb.successes = 0
+		cb.probing = 0
+		cb.halfOpens++
+	}
+
+	if cb.state == BreakerHalfOpen {
+		if cb.probing >= cb.conf.HalfOpenRequests {
+			return a, &CircuitOpenError{Host: cb.host}
+		}
+		cb.probing++
+		a = admission{probe: true, halfOpen: cb.halfOpens}
+	}
+
+	return
+}
+
+// record feeds the outcome of a call admitted by allow
+func (cb *circuitBreaker) record(a admission, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		// only probes of this half-open period decide, calls admitted while closed may finish late
+		if !a.probe || a.halfOpen != cb.halfOpens {
+			return
+		}
+
+		cb.probing--
+		if failed {
+			cb.trip()
+			return
+		}
+
+		cb.successes++
+		if cb.successes >= cb.conf.HalfOpenRequests {
+			cb.state = BreakerClosed
+			cb.failures = 0
+			logger.WithField("host", cb.host).Info("circuit breaker closed")
+		}
+	case BreakerClosed:
+		if !failed {
+			cb.failures = 0
+			return
+		}
+
+		cb.failures++
+		if cb.failures >= cb.conf.FailureThreshold {
+			cb.trip()
+		}
+	}
+}
+
+// release frees the probe slot of a call that never reached upstream, its outcome does not count
+func (cb *circuitBreaker) release(a admission) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerHalfOpen && a.probe && a.halfOpen == cb.halfOpens {
+		cb.probing--
+	}
+}
+
+func (cb *circuitBreaker) trip() {
+	cb.state = BreakerOpen
+	cb.openedAt = time.Now()
+	cb.failures = 0
+
+	logger.WithField("host", cb.host).Warnf("circuit breaker open for %s", cb.openDuration())
+}
+
+// isBreakerFailure tells whether a call outcome counts against upstream health,
+// 4xx, callers giving up and client side rate limiting are not the upstream's fault
+func isBreakerFailure(httpCode int, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var rateLimitedErr *RateLimitedError
+	if errors.Is(err, context.Canceled) || errors.As(err, &rateLimitedErr) {
+		return false
+	}
+
+	return httpCode == 0 || httpCode >= 500 || httpCode == 429
+}
@@ -0,0 +1,118 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(conf breakerConfig) *circuitBreaker {
+	return &circuitBreaker{host: "breaker.test", conf: (&conf).withDefaults()}
+}
+
+// elapse ends the open period of cb
+func elapse(cb *circuitBreaker) {
+	cb.openedAt = time.Now().Add(-cb.openDuration())
+}
+
+func mustAllow(t *testing.T, cb *circuitBreaker) admission {
+	t.Helper()
+
+	a, err := cb.allow()
+	if err != nil {
+		t.Fatalf("allow: %s", err)
+	}
+
+	return a
+}
+
+func TestBreakerTripsAndFailsFast(t *testing.T) {
+	cb := newTestBreaker(breakerConfig{FailureThreshold: 3})
+
+	for i := 0; i < 3; i++ {
+		cb.record(mustAllow(t, cb), true)
+	}
+	if cb.state != BreakerOpen {
+		t.Fatalf("state %s, want open", cb.state)
+	}
+
+	_, err := cb.allow()
+
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || openErr.RetryAfter <= 0 {
+		t.Fatalf("err %v, want CircuitOpenError with RetryAfter", err)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	cb := newTestBreaker(breakerConfig{FailureThreshold: 2})
+
+	cb.record(mustAllow(t, cb), true)
+	cb.record(mustAllow(t, cb), false)
+	cb.record(mustAllow(t, cb), true)
+
+	if cb.state != BreakerClosed {
+		t.Fatalf("state %s, want closed", cb.state)
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	cb := newTestBreaker(breakerConfig{FailureThreshold: 1, HalfOpenRequests: 2})
+	cb.record(mustAllow(t, cb), true)
+	elapse(cb)
+
+	first, second := mustAllow(t, cb), mustAllow(t, cb)
+	if _, err := cb.allow(); err == nil {
+		t.Fatal("third probe allowed, want 2 at most")
+	}
+
+	cb.record(first, false)
+	if cb.state != BreakerHalfOpen {
+		t.Fatalf("state %s after one probe, want half-open", cb.state)
+	}
+
+	cb.record(second, false)
+	if cb.state != BreakerClosed {
+		t.Fatalf("state %s after probes, want closed", cb.state)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	cb := newTestBreaker(breakerConfig{FailureThreshold: 1})
+	cb.record(mustAllow(t, cb), true)
+	elapse(cb)
+
+	cb.record(mustAllow(t, cb), true)
+	if cb.state != BreakerOpen {
+		t.Fatalf("state %s, want open", cb.state)
+	}
+}
+
+func TestBreakerIgnoresLateCalls(t *testing.T) {
+	cb := newTestBreaker(breakerConfig{FailureThreshold: 1, HalfOpenRequests: 1})
+
+	// admitted while closed, still running when the breaker trips and turns half-open
+	late := mustAllow(t, cb)
+	cb.record(mustAllow(t, cb), true)
+	elapse(cb)
+
+	probe := mustAllow(t, cb)
+	cb.record(late, false)
+
+	if cb.probing != 1 || cb.state != BreakerHalfOpen {
+		t.Fatalf("probing %d state %s after a late call, want 1 half-open", cb.probing, cb.state)
+	}
+	if _, err := cb.allow(); err == nil {
+		t.Fatal("extra probe allowed after a late call")
+	}
+
+	// a probe of a former half-open period neither
+	cb.record(probe, true)
+	elapse(cb)
+	mustAllow(t, cb)
+	cb.record(probe, false)
+
+	if cb.probing != 1 {
+		t.Fatalf("probing %d after a stale probe, want 1", cb.probing)
+	}
+}
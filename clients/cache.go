@@ -0,0 +1,200 @@
+package clients
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go-cygnus/utils/metrics"
+)
+
+const DefaultCacheSize = 1000
+
+// CacheEntry is a cached GET response body
+type CacheEntry struct {
+	Body    []byte
+	ETag    string
+	Expires time.Time
+}
+
+// Fresh tells whether the entry can be served without asking upstream
+func (e *CacheEntry) Fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+// CacheStore keeps responses by key, an expired entry is still kept for ETag revalidation until evicted
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// LRUCache is an in-memory CacheStore evicting the least recently used entry beyond its capacity
+type LRUCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently used
+}
+
+type lruItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity <= 0 {
+		capacity = DefaultCacheSize
+	}
+
+	return &LRUCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *LRUCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*lruItem).entry, true
+}
+
+func (c *LRUCache) Set(key string, entry *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruItem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Len is the count of cached entries, expired ones included
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// cacheConfig is the clients.yml form of response caching of an endpoint
+type cacheConfig struct {
+	TTL int `yaml:"ttl" validate:"min=0"` // seconds, 0 disables caching
+}
+
+var (
+	responseCache     CacheStore = NewLRUCache(DefaultCacheSize)
+	responseCacheLock sync.RWMutex
+
+	clientCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "client",
+		Name:      "cache_lookups_total",
+		Help:      "Response cache lookups of outbound GET calls, result is hit, miss or revalidated.",
+	}, []string{"client", "result"})
+)
+
+func init() {
+	metrics.MustRegister(clientCacheLookups)
+}
+
+// SetCacheStore replaces the store shared by every client, e.g. with a redis one
+func SetCacheStore(store CacheStore) {
+	responseCacheLock.Lock()
+	defer responseCacheLock.Unlock()
+
+	responseCache = store
+}
+
+func cacheStore() CacheStore {
+	responseCacheLock.RLock()
+	defer responseCacheLock.RUnlock()
+
+	return responseCache
+}
+
+type cacheTTLContextKey struct{}
+
+// WithCacheTTL overrides the cache ttl of the client for calls made with ctx, 0 bypasses the cache
+func WithCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheTTLContextKey{}, ttl)
+}
+
+// cacheTTL of req, only GET without a caller's own If-None-Match is cached
+func (b *baseRest) cacheTTL(ctx context.Context, req *http.Request) time.Duration {
+	if req.Method != http.MethodGet || req.Header.Get("If-None-Match") != "" {
+		return 0
+	}
+
+	if ttl, ok := ctx.Value(cacheTTLContextKey{}).(time.Duration); ok {
+		return ttl
+	}
+
+	return b.Config.CacheTTL
+}
+
+// uncachedHeaders differ on every call, they are left out of cache keys
+var uncachedHeaders = map[string]bool{
+	http.CanonicalHeaderKey(HeaderReqID):       true,
+	http.CanonicalHeaderKey(HeaderTraceParent): true,
+}
+
+// cacheKey is of the client, url and request headers, a response is never served to a caller sending
+// other credentials or other values of headers it may vary by. Headers are hashed to keep secrets out of stores
+func (b *baseRest) cacheKey(req *http.Request) string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !uncachedHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		_, _ = fmt.Fprintf(h, "%s: %q\n", name, req.Header[name])
+	}
+
+	return b.clientName() + " " + req.URL.String() + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+func (b *baseRest) countCacheLookup(result string) {
+	clientCacheLookups.WithLabelValues(b.clientName(), result).Inc()
+}
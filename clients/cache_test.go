@@ -0,0 +1,147 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-cygnus/utils/logging"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", &CacheEntry{Body: []byte("a")})
+	c.Set("b", &CacheEntry{Body: []byte("b")})
+
+	// a is used, b is the least recently used one
+	c.Get("a")
+	c.Set("c", &CacheEntry{Body: []byte("c")})
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("len %d, want 2", c.Len())
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls, revalidations int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`{"value":"v1"}`))
+	}))
+	defer srv.Close()
+
+	rest := &baseRest{
+		Name:   "cached",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+		Config: baseConfig{CacheTTL: 50 * time.Millisecond},
+	}
+
+	get := func(ctx context.Context) string {
+		t.Helper()
+
+		var out struct {
+			Value string `json:"value"`
+		}
+		if err := rest.JsonWithContext(ctx, http.MethodGet, "x", nil, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		return out.Value
+	}
+
+	ctx := context.Background()
+	if get(ctx) != "v1" || get(ctx) != "v1" {
+		t.Fatal("unexpected body")
+	}
+	if calls != 1 {
+		t.Fatalf("%d upstream calls, want 1 then a hit", calls)
+	}
+
+	if get(WithCacheTTL(ctx, 0)) != "v1" || calls != 2 {
+		t.Fatalf("%d upstream calls, want the bypass to reach upstream", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if get(ctx) != "v1" || revalidations != 1 {
+		t.Fatalf("%d revalidations of the expired entry, want 1", revalidations)
+	}
+
+	// revalidated entries are fresh again
+	if get(ctx) != "v1" || calls != 3 {
+		t.Fatalf("%d upstream calls, want 3", calls)
+	}
+
+	// other methods are never cached
+	if err := rest.JsonWithContext(ctx, http.MethodPut, "x", nil, nil); err != nil || calls != 4 {
+		t.Fatalf("put err %v, %d upstream calls, want 4", err, calls)
+	}
+}
+
+func TestResponseCacheKeyedByHeaders(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		if r.URL.Path == "/any" {
+			w.Header().Set("Vary", "*")
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+
+	rest := &baseRest{
+		Name:   "keyed",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+		Config: baseConfig{CacheTTL: time.Minute},
+	}
+
+	get := func(path, auth, reqID string) string {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", auth)
+
+		data, err := rest.DoWithContext(logging.ContextWithReqID(context.Background(), reqID), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(data)
+	}
+
+	if get("/x", "alice", "r1") != "alice" || get("/x", "bob", "r2") != "bob" {
+		t.Fatal("response of one caller served to another")
+	}
+
+	// request ids differ on every call and do not miss the cache
+	if get("/x", "alice", "r3") != "alice" || calls != 2 {
+		t.Fatalf("%d upstream calls, want 2", calls)
+	}
+
+	get("/any", "alice", "r4")
+	get("/any", "alice", "r5")
+	if calls != 4 {
+		t.Fatalf("%d upstream calls, want Vary * not cached", calls)
+	}
+}
@@ -0,0 +1,343 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go-cygnus/utils/validators"
+)
+
+type BodyEncoding int
+
+const (
+	EncodeJSON BodyEncoding = iota
+	EncodeForm
+	EncodeMultipart
+	// EncodeNone sends no body, e.g. GET with everything in query
+	EncodeNone
+)
+
+// CallSpec describes an upstream api for Call
+type CallSpec struct {
+	Method string
+	// Path is relative to URIBase, {name} placeholders are filled from PathParams, e.g. envs/{env}/apps/{app_id}
+	Path       string
+	PathParams map[string]string
+	Query      url.Values
+	Headers    map[string]string
+	Encoding   BodyEncoding
+	// Decoder overrides json for responses other than []byte, string and encoding.TextUnmarshaler, e.g. xml.Unmarshal
+	Decoder func(data []byte, out interface{}) error
+	// Validate checks the response with binding tags, same as dto requests, elements of slices and maps included.
+	// A response failing it is an *InvalidResponseError
+	Validate bool
+}
+
+// MultipartFile is a file part of MultipartBody
+type MultipartFile struct {
+	Field       string
+	FileName    string
+	ContentType string // optional, application/octet-stream by default
+	Content     []byte
+}
+
+// MultipartBody is the Req of a EncodeMultipart call
+type MultipartBody struct {
+	Fields map[string]string
+	Files  []MultipartFile
+}
+
+var (
+	pathParamPattern  = regexp.MustCompile(`{([^{}/]+)}`)
+	responseValidator = &validators.DefaultValidator{}
+)
+
+// Call sends req to rest as described by spec and decodes the response into Rsp, e.g.
+//
+//	item, err := clients.Call[clients.ApolloItem, clients.ApolloItem](ctx, rest, clients.CallSpec{
+//		Method:     http.MethodPost,
+//		Path:       "envs/{env}/apps/{app_id}/clusters/{cluster}/namespaces/{namespace}/items",
+//		PathParams: map[string]string{"env": "DEV", "app_id": "app", "cluster": "default", "namespace": "application"},
+//	}, clients.ApolloItem{Key: "k", Value: "v", DataChangeCreatedBy: "me"})
+func Call[Req any, Rsp any](ctx context.Context, rest *baseRest, spec CallSpec, req Req) (rsp Rsp, err error) {
+	// the template keeps metrics free of ids
+	ctx = WithRoute(ctx, spec.Path)
+
+	httpReq, err := rest.newCallRequest(ctx, spec, req)
+	if err != nil {
+		return
+	}
+
+	rspData, err := rest.DoWithContext(ctx, httpReq)
+	if err != nil {
+		return
+	}
+
+	if err = decodeResponse(rspData, &rsp, spec.Decoder); err != nil {
+		return
+	}
+
+	if spec.Validate {
+		if validateErr := validateResponse(reflect.ValueOf(&rsp), ""); validateErr != nil {
+			err = &InvalidResponseError{Method: httpReq.Method, URL: httpReq.URL.String(), Err: validateErr}
+		}
+	}
+
+	return
+}
+
+// validateResponse checks structs found in v, path locates elements in the error, e.g. [2] or [key]
+func validateResponse(v reflect.Value, path string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.Struct:
+		// map values are not addressable
+		if !v.CanAddr() {
+			copied := reflect.New(v.Type())
+			copied.Elem().Set(v)
+			v = copied.Elem()
+		}
+		err = responseValidator.ValidateStruct(v.Addr().Interface())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len() && err == nil; i++ {
+			err = validateResponse(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+		return err
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() && err == nil {
+			err = validateResponse(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()))
+		}
+		return err
+	}
+
+	if err != nil && path != "" {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return err
+}
+
+func (b *baseRest) newCallRequest(ctx context.Context, spec CallSpec, payload interface{}) (req *http.Request, err error) {
+	path, err := expandPath(spec.Path, spec.PathParams)
+	if err != nil {
+		return
+	}
+
+	parts := []string{fmt.Sprintf("%s://%s", b.Scheme, b.Host)}
+	for _, part := range []string{b.URIBase, path} {
+		if part = strings.Trim(part, "/"); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	reqURL := strings.Join(parts, "/")
+	if len(spec.Query) > 0 {
+		reqURL += "?" + spec.Query.Encode()
+	}
+
+	body, contentType, err := encodeBody(spec.Encoding, payload)
+	if err != nil {
+		return
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, method, reqURL, body); err != nil {
+		return
+	}
+
+	req.Header.Set("Accept", "application/json, */*")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	for k, v := range b.Config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return
+}
+
+// expandPath fills {name} placeholders, a placeholder without param is an error
+func expandPath(template string, params map[string]string) (path string, err error) {
+	path = pathParamPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+
+		value, ok := params[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("no path param %s for %s", name, template)
+		}
+
+		return url.PathEscape(value)
+	})
+
+	return
+}
+
+func encodeBody(enc BodyEncoding, payload interface{}) (body io.Reader, contentType string, err error) {
+	if enc == EncodeNone || payload == nil {
+		return
+	}
+
+	// a nil pointer Req sends no body either
+	if v := reflect.ValueOf(payload); v.Kind() == reflect.Ptr && v.IsNil() {
+		return
+	}
+
+	switch enc {
+	case EncodeForm:
+		var form url.Values
+		if form, err = formValues(payload); err != nil {
+			return
+		}
+		return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded", nil
+	case EncodeMultipart:
+		return multipartBody(payload)
+	default:
+		var data []byte
+		if data, err = json.Marshal(payload); err != nil {
+			return
+		}
+		return bytes.NewReader(data), "application/json", nil
+	}
+}
+
+// formValues accepts url.Values, map[string]string or a struct with form tags, the same tags gin binds
+func formValues(payload interface{}) (form url.Values, err error) {
+	switch p := payload.(type) {
+	case url.Values:
+		return p, nil
+	case map[string]string:
+		form = url.Values{}
+		for k, v := range p {
+			form.Set(k, v)
+		}
+		return
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(payload))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("form body must be url.Values, map[string]string or struct, got %T", payload)
+	}
+
+	form = url.Values{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		name := strings.SplitN(field.Tag.Get("form"), ",", 2)[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Slice {
+			for j := 0; j < fieldValue.Len(); j++ {
+				form.Add(name, fmt.Sprint(fieldValue.Index(j).Interface()))
+			}
+			continue
+		}
+
+		form.Set(name, fmt.Sprint(fieldValue.Interface()))
+	}
+
+	return
+}
+
+func multipartBody(payload interface{}) (body io.Reader, contentType string, err error) {
+	var mb MultipartBody
+	switch p := payload.(type) {
+	case MultipartBody:
+		mb = p
+	case *MultipartBody:
+		mb = *p
+	default:
+		return nil, "", fmt.Errorf("multipart body must be MultipartBody, got %T", payload)
+	}
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	for k, v := range mb.Fields {
+		if err = writer.WriteField(k, v); err != nil {
+			return
+		}
+	}
+
+	for _, f := range mb.Files {
+		var part io.Writer
+		if f.ContentType == "" {
+			part, err = writer.CreateFormFile(f.Field, f.FileName)
+		} else {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, f.Field, f.FileName))
+			header.Set("Content-Type", f.ContentType)
+			part, err = writer.CreatePart(header)
+		}
+		if err != nil {
+			return
+		}
+
+		if _, err = part.Write(f.Content); err != nil {
+			return
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return
+	}
+
+	return buf, writer.FormDataContentType(), nil
+}
+
+// decodeResponse keeps []byte and string raw, then tries encoding.TextUnmarshaler, decoder and json in order
+func decodeResponse(data []byte, out interface{}, decoder func([]byte, interface{}) error) error {
+	switch o := out.(type) {
+	case *[]byte:
+		*o = data
+		return nil
+	case *string:
+		*o = string(data)
+		return nil
+	case encoding.TextUnmarshaler:
+		return o.UnmarshalText(data)
+	}
+
+	if decoder != nil {
+		return decoder(data, out)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
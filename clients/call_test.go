@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// echoed is what echoServer received
+type echoed struct {
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Query     url.Values        `json:"query"`
+	Header    http.Header       `json:"header"`
+	Form      url.Values        `json:"form"`
+	Files     map[string]string `json:"files"`
+	FileTypes map[string]string `json:"file_types"`
+	Body      string            `json:"body"`
+}
+
+// echoServer answers every request with its echoed form, or with the raw body under /raw
+func echoServer(t *testing.T) *baseRest {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/raw" {
+			_, _ = w.Write([]byte("plain text"))
+			return
+		}
+
+		e := echoed{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.Query(), Header: r.Header}
+
+		switch {
+		case strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data"):
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			e.Form = url.Values(r.MultipartForm.Value)
+			e.Files, e.FileTypes = map[string]string{}, map[string]string{}
+			for field, headers := range r.MultipartForm.File {
+				f, _ := headers[0].Open()
+				content, _ := ioutil.ReadAll(f)
+				e.Files[field] = headers[0].Filename + ":" + string(content)
+				e.FileTypes[field] = headers[0].Header.Get("Content-Type")
+			}
+		case r.Header.Get("Content-Type") == "application/x-www-form-urlencoded":
+			_ = r.ParseForm()
+			e.Form = r.PostForm
+		default:
+			body, _ := ioutil.ReadAll(r.Body)
+			e.Body = string(body)
+		}
+
+		_ = json.NewEncoder(w).Encode(e)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &baseRest{
+		Name:    "echo",
+		Scheme:  "http",
+		Host:    strings.TrimPrefix(srv.URL, "http://"),
+		URIBase: "/api/",
+		Config:  baseConfig{Breaker: &breakerConfig{Disabled: true}, Headers: map[string]string{"X-Client": "cygnus"}},
+	}
+}
+
+func TestCallPathAndQuery(t *testing.T) {
+	rest := echoServer(t)
+
+	rsp, err := Call[any, echoed](context.Background(), rest, CallSpec{
+		Path:       "envs/{env}/items/{key}",
+		PathParams: map[string]string{"env": "DEV", "key": "a/b c"},
+		Query:      url.Values{"page": {"2"}, "tag": {"x", "y"}},
+		Headers:    map[string]string{"X-Call": "1"},
+		Encoding:   EncodeNone,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsp.Method != http.MethodGet || rsp.Path != "/api/envs/DEV/items/a%2Fb%20c" {
+		t.Errorf("requested %s %s", rsp.Method, rsp.Path)
+	}
+	if rsp.Query.Get("page") != "2" || len(rsp.Query["tag"]) != 2 {
+		t.Errorf("query %v", rsp.Query)
+	}
+	if rsp.Header.Get("X-Client") != "cygnus" || rsp.Header.Get("X-Call") != "1" {
+		t.Errorf("headers %v", rsp.Header)
+	}
+
+	_, err = Call[any, echoed](context.Background(), rest, CallSpec{Path: "envs/{env}"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "no path param env") {
+		t.Fatalf("err %v, want missing path param", err)
+	}
+}
+
+func TestCallJSONBody(t *testing.T) {
+	type item struct {
+		Key string `json:"key"`
+	}
+
+	rsp, err := Call[item, echoed](context.Background(), echoServer(t), CallSpec{Method: http.MethodPost, Path: "items"}, item{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Body != `{"key":"k"}` || rsp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("body %s of %s", rsp.Body, rsp.Header.Get("Content-Type"))
+	}
+}
+
+func TestCallFormBody(t *testing.T) {
+	type login struct {
+		User    string   `form:"user"`
+		Scopes  []string `form:"scope"`
+		Ignored string   `form:"-"`
+		Plain   int
+	}
+
+	rest := echoServer(t)
+	ctx := context.Background()
+	spec := CallSpec{Method: http.MethodPost, Path: "login", Encoding: EncodeForm}
+
+	rsp, err := Call[login, echoed](ctx, rest, spec, login{User: "u", Scopes: []string{"a", "b"}, Ignored: "x", Plain: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{"user": {"u"}, "scope": {"a", "b"}, "Plain": {"3"}}
+	if rsp.Form.Encode() != want.Encode() {
+		t.Errorf("form %v, want %v", rsp.Form, want)
+	}
+
+	if rsp, err = Call[map[string]string, echoed](ctx, rest, spec, map[string]string{"user": "m"}); err != nil || rsp.Form.Get("user") != "m" {
+		t.Errorf("map form %v err %v", rsp.Form, err)
+	}
+
+	if _, err = Call[int, echoed](ctx, rest, spec, 1); err == nil {
+		t.Error("int encoded as form")
+	}
+}
+
+func TestCallMultipartBody(t *testing.T) {
+	rsp, err := Call[MultipartBody, echoed](context.Background(), echoServer(t), CallSpec{
+		Method:   http.MethodPost,
+		Path:     "upload",
+		Encoding: EncodeMultipart,
+	}, MultipartBody{
+		Fields: map[string]string{"comment": "c"},
+		Files: []MultipartFile{
+			{Field: "doc", FileName: "a.txt", Content: []byte("A")},
+			{Field: "img", FileName: "b.png", ContentType: "image/png", Content: []byte("B")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsp.Form.Get("comment") != "c" || rsp.Files["doc"] != "a.txt:A" || rsp.Files["img"] != "b.png:B" {
+		t.Fatalf("multipart form %v files %v", rsp.Form, rsp.Files)
+	}
+	if rsp.FileTypes["doc"] != "application/octet-stream" || rsp.FileTypes["img"] != "image/png" {
+		t.Fatalf("file types %v", rsp.FileTypes)
+	}
+}
+
+func TestCallRawResponse(t *testing.T) {
+	rest := echoServer(t)
+	ctx := context.Background()
+	spec := CallSpec{Path: "raw", Encoding: EncodeNone}
+
+	if text, err := Call[any, string](ctx, rest, spec, nil); err != nil || text != "plain text" {
+		t.Errorf("string %q err %v", text, err)
+	}
+	if data, err := Call[any, []byte](ctx, rest, spec, nil); err != nil || string(data) != "plain text" {
+		t.Errorf("bytes %q err %v", data, err)
+	}
+
+	decoded, err := Call[any, string](ctx, rest, CallSpec{Path: "raw", Decoder: func(data []byte, out interface{}) error {
+		return errors.New("decoder used for string")
+	}}, nil)
+	if err != nil || decoded != "plain text" {
+		t.Errorf("string %q err %v", decoded, err)
+	}
+}
+
+func TestCallValidate(t *testing.T) {
+	type checked struct {
+		Method string `json:"method" binding:"required"`
+		Absent string `json:"absent" binding:"required"`
+	}
+
+	rest := echoServer(t)
+	ctx := context.Background()
+	spec := CallSpec{Path: "x", Validate: true}
+
+	_, err := Call[any, checked](ctx, rest, spec, nil)
+
+	var invalidErr *InvalidResponseError
+	if !errors.As(err, &invalidErr) || !strings.Contains(invalidErr.URL, "/api/x") {
+		t.Fatalf("err %v, want *InvalidResponseError", err)
+	}
+
+	// elements of slices and maps are checked too
+	listSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"method":"GET","absent":"a"},{"method":"GET"}]`))
+	}))
+	defer listSrv.Close()
+	rest.Host, rest.URIBase = strings.TrimPrefix(listSrv.URL, "http://"), ""
+
+	if _, err = Call[any, []checked](ctx, rest, spec, nil); !errors.As(err, &invalidErr) || !strings.Contains(err.Error(), "[1]") {
+		t.Fatalf("err %v, want *InvalidResponseError of [1]", err)
+	}
+	if _, err = Call[any, map[string]checked](ctx, rest, CallSpec{Path: "x", Validate: true, Decoder: func(data []byte, out interface{}) error {
+		*out.(*map[string]checked) = map[string]checked{"ok": {Method: "GET", Absent: "a"}, "bad": {}}
+		return nil
+	}}, nil); !errors.As(err, &invalidErr) || !strings.Contains(err.Error(), "[bad]") {
+		t.Fatalf("err %v, want *InvalidResponseError of [bad]", err)
+	}
+}
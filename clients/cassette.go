@@ -0,0 +1,288 @@
+package clients
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cassette modes, a cassette records upstream interactions into a fixture file and replays them offline
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+var (
+	cassetteMode string
+	cassettePath string
+
+	// activeCassette wraps the transport of every client while set, see UseCassette
+	activeCassette     *CassetteTransport
+	activeCassetteLock sync.RWMutex
+)
+
+func init() {
+	flag.StringVar(&cassetteMode, "clientsCassette", "", "record or replay rest client calls, overrides clients.yml")
+	flag.StringVar(&cassettePath, "clientsCassettePath", "", "cassette fixture file, overrides clients.yml")
+}
+
+type cassetteConfig struct {
+	Mode string `yaml:"mode" validate:"omitempty,oneof=record replay"`
+	Path string `yaml:"path" validate:"required_with=Mode"`
+}
+
+type CassetteRequest struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	StatusCode int         `yaml:"status_code"`
+	Header     http.Header `yaml:"header,omitempty"`
+	Body       string      `yaml:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  CassetteRequest  `yaml:"request"`
+	Response CassetteResponse `yaml:"response"`
+}
+
+// CassetteTransport is a http.RoundTripper recording to or replaying from a cassette file,
+// requests are matched by method, url and body
+type CassetteTransport struct {
+	Mode string
+	Path string
+	// Next sends real requests in record mode, http.DefaultTransport if not set
+	Next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	// replayed counts how many times each request key has been served, so repeated calls replay in order
+	replayed map[string]int
+}
+
+// NewCassetteTransport loads the cassette at path, a missing file is only allowed in record mode
+func NewCassetteTransport(mode string, path string, next http.RoundTripper) (*CassetteTransport, error) {
+	if mode != CassetteRecord && mode != CassetteReplay {
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	t := &CassetteTransport{
+		Mode:     mode,
+		Path:     path,
+		Next:     next,
+		replayed: make(map[string]int),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && mode == CassetteRecord:
+		return t, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err = yaml.Unmarshal(content, &t.interactions); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %s", path, err)
+	}
+
+	return t, nil
+}
+
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTrip(req, t.Next)
+}
+
+// roundTrip records through next, so a cassette can be shared by clients of different transports
+func (t *CassetteTransport) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	recorded := CassetteRequest{Method: req.Method, URL: req.URL.String(), Body: string(reqBody)}
+
+	if t.Mode == CassetteReplay {
+		return t.replay(req, recorded)
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return t.record(req, recorded, next)
+}
+
+func (t *CassetteTransport) replay(req *http.Request, recorded CassetteRequest) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := fmt.Sprintf("%s %s %s", recorded.Method, recorded.URL, recorded.Body)
+
+	var matched []Interaction
+	for _, i := range t.interactions {
+		if i.Request == recorded {
+			matched = append(matched, i)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("cassette %s has no interaction for %s %s", t.Path, recorded.Method, recorded.URL)
+	}
+
+	// replay in recorded order, stick to the last one when running out
+	n := t.replayed[key]
+	if n >= len(matched) {
+		n = len(matched) - 1
+	}
+	t.replayed[key]++
+
+	rsp := matched[n].Response
+
+	header := rsp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rsp.StatusCode, http.StatusText(rsp.StatusCode)),
+		StatusCode:    rsp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(rsp.Body)),
+		ContentLength: int64(len(rsp.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *CassetteTransport) record(req *http.Request, recorded CassetteRequest, next http.RoundTripper) (*http.Response, error) {
+	rsp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(rspBody))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.interactions = append(t.interactions, Interaction{
+		Request: recorded,
+		Response: CassetteResponse{
+			StatusCode: rsp.StatusCode,
+			Header:     rsp.Header.Clone(),
+			Body:       string(rspBody),
+		},
+	})
+
+	// persist on every interaction, a recording process is usually killed rather than shut down.
+	// A RoundTripper returns a response or an error, never both, the call itself went fine
+	if err = t.save(); err != nil {
+		logger.WithError(err).WithField("cassette", t.Path).Error("save cassette failed")
+	}
+
+	return rsp, nil
+}
+
+func (t *CassetteTransport) save() error {
+	content, err := yaml.Marshal(t.interactions)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(t.Path), os.ModePerm); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.Path, content, 0644)
+}
+
+// UseCassette plugs a cassette into the transport of every client, call restore to unplug it.
+// Tests use it directly, services enable it through clients.yml or -clientsCassette.
+func UseCassette(mode string, path string) (restore func(), err error) {
+	t, err := NewCassetteTransport(mode, path, nil)
+	if err != nil {
+		return
+	}
+
+	activeCassetteLock.Lock()
+	origin := activeCassette
+	activeCassette = t
+	activeCassetteLock.Unlock()
+
+	logger.WithField("cassette", path).Infof("rest clients in %s mode", mode)
+
+	return func() {
+		activeCassetteLock.Lock()
+		defer activeCassetteLock.Unlock()
+
+		activeCassette = origin
+	}, nil
+}
+
+// cassetteRoundTripper sends through the active cassette, recording with the transport of the client
+type cassetteRoundTripper struct {
+	cassette *CassetteTransport
+	next     http.RoundTripper
+}
+
+func (c *cassetteRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.cassette.roundTrip(req, c.next)
+}
+
+// withCassette returns client itself unless a cassette is active
+func withCassette(client *http.Client) *http.Client {
+	activeCassetteLock.RLock()
+	t := activeCassette
+	activeCassetteLock.RUnlock()
+
+	if t == nil {
+		return client
+	}
+
+	wrapped := *client
+	wrapped.Transport = &cassetteRoundTripper{cassette: t, next: client.Transport}
+
+	return &wrapped
+}
+
+// initCassette enables the cassette from flags first, then clients.yml
+func initCassette(conf *cassetteConfig) {
+	mode, path := cassetteMode, cassettePath
+	if conf != nil {
+		if mode == "" {
+			mode = conf.Mode
+		}
+		if path == "" {
+			path = conf.Path
+		}
+	}
+
+	if mode == "" {
+		return
+	}
+
+	if _, err := UseCassette(mode, path); err != nil {
+		panic(fmt.Sprintf("invalid restclient cassette: %s", err))
+	}
+}
@@ -0,0 +1,73 @@
+package clients
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.yml")
+
+	recorder, err := NewCassetteTransport(CassetteRecord, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&http.Client{Transport: recorder}).Get(srv.URL + "/x"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+
+	player, err := NewCassetteTransport(CassetteReplay, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := (&http.Client{Transport: player}).Get(srv.URL + "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	body, _ := ioutil.ReadAll(rsp.Body)
+	if rsp.StatusCode != http.StatusOK || string(body) != `{"ok":true}` {
+		t.Fatalf("replayed %d %s", rsp.StatusCode, body)
+	}
+}
+
+func TestCassetteSaveFailureKeepsResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewCassetteTransport(CassetteRecord, filepath.Join(dir, "cassette.yml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent of the cassette is a file, saving always fails
+	parent := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(parent, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Path = filepath.Join(parent, "cassette.yml")
+
+	rsp, err := (&http.Client{Transport: recorder}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("err %s, want the response", err)
+	}
+	_ = rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", rsp.StatusCode)
+	}
+}
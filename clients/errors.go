@@ -0,0 +1,92 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// HTTPError is returned by baseRest.Do when upstream answers a non-2xx code
+type HTTPError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	Body       []byte
+	// Message is the upstream error message parsed from Body, empty if Body has none
+	Message string
+}
+
+func newHTTPError(method string, url string, statusCode int, body []byte) *HTTPError {
+	return &HTTPError{
+		StatusCode: statusCode,
+		Method:     method,
+		URL:        url,
+		Body:       body,
+		Message:    parseUpstreamMessage(body),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("Invalid http %d when rest %s: %s", e.StatusCode, e.URL, e.Message)
+	}
+
+	return fmt.Sprintf("Invalid http %d when rest %s: %s", e.StatusCode, e.URL, string(e.Body))
+}
+
+// InvalidResponseError is an upstream response failing CallSpec.Validate, the upstream is to blame, not the caller
+type InvalidResponseError struct {
+	Method string
+	URL    string
+	Err    error
+}
+
+func (e *InvalidResponseError) Error() string {
+	return fmt.Sprintf("Invalid response when rest %s: %s", e.URL, e.Err)
+}
+
+func (e *InvalidResponseError) Unwrap() error {
+	return e.Err
+}
+
+// parseUpstreamMessage tries the usual json error bodies, e.g. apollo {"status": 404, "message": "..."}
+func parseUpstreamMessage(body []byte) string {
+	var rsp struct {
+		Message      string `json:"message"`
+		Msg          string `json:"msg"`
+		Error        string `json:"error"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return ""
+	}
+
+	for _, msg := range []string{rsp.Message, rsp.Msg, rsp.ErrorMessage, rsp.Error} {
+		if msg != "" {
+			return msg
+		}
+	}
+
+	return ""
+}
+
+// CircuitOpenError is returned without calling upstream while its circuit breaker is open
+type CircuitOpenError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s, retry after %s", e.Host, e.RetryAfter)
+}
+
+// RateLimitedError is returned by a fail fast client limiter when the quota is used up
+type RateLimitedError struct {
+	Client     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("client %s rate limited, retry after %s", e.Client, e.RetryAfter)
+}
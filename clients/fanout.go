@@ -0,0 +1,121 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const DefaultFanOutConcurrency = 8
+
+type FanOutOptions struct {
+	// Concurrency bounds the calls in flight, DefaultFanOutConcurrency if not set
+	Concurrency int
+	// CancelOnError cancels the calls left once one fails, they get a *FanOutCanceledError
+	CancelOnError bool
+}
+
+// FanOutCanceledError is the error of a call canceled by CancelOnError, Cause is the failure which canceled it.
+// It is a context.Canceled for errors.Is
+type FanOutCanceledError struct {
+	Cause error
+}
+
+func (e *FanOutCanceledError) Error() string {
+	return fmt.Sprintf("canceled as another call failed: %s", e.Cause)
+}
+
+func (e *FanOutCanceledError) Unwrap() error {
+	return context.Canceled
+}
+
+// FanOut runs call for every req concurrently, rsps and errs are in the order of reqs.
+// Calls wait for the rate limiter of their client even in fail fast mode, so a bulk job stays inside quota, e.g.
+//
+//	namespaces, errs := clients.FanOut(ctx, reqs, clients.FanOutOptions{Concurrency: 4}, apollo.GetNamespaceInfo)
+func FanOut[Req any, Rsp any](
+	ctx context.Context, reqs []Req, opts FanOutOptions, call func(context.Context, Req) (Rsp, error),
+) (rsps []Rsp, errs []error) {
+	rsps = make([]Rsp, len(reqs))
+	errs = make([]error, len(reqs))
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultFanOutConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(withRateLimitBlock(ctx))
+	defer cancel()
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+
+		failOnce sync.Once
+		failed   = -1 // index of the first call failing, in time
+	)
+
+	for i := range reqs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		// checked again, select picks randomly when both are ready
+		if err := ctx.Err(); err != nil {
+			<-sem
+			errs[i] = err
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			rsps[i], errs[i] = call(ctx, reqs[i])
+			if errs[i] != nil && opts.CancelOnError {
+				failOnce.Do(func() {
+					failed = i
+					cancel()
+				})
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if failed >= 0 {
+		for i, err := range errs {
+			if i != failed && errors.Is(err, context.Canceled) {
+				errs[i] = &FanOutCanceledError{Cause: errs[failed]}
+			}
+		}
+	}
+
+	return
+}
+
+// FirstError is the failure which canceled the others under CancelOnError, or else the non-nil error
+// of the lowest index
+func FirstError(errs []error) error {
+	var canceledErr *FanOutCanceledError
+	for _, err := range errs {
+		if errors.As(err, &canceledErr) {
+			return canceledErr.Cause
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
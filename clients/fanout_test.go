@@ -0,0 +1,120 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutOrder(t *testing.T) {
+	reqs := []int{30, 0, 20, 10}
+
+	var inFlight, maxInFlight int32
+	rsps, errs := FanOut(context.Background(), reqs, FanOutOptions{Concurrency: 2},
+		func(ctx context.Context, delay int) (int, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+			return delay * 2, nil
+		})
+
+	for i, delay := range reqs {
+		if errs[i] != nil || rsps[i] != delay*2 {
+			t.Fatalf("rsps[%d] %d, errs[%d] %v, want %d", i, rsps[i], i, errs[i], delay*2)
+		}
+	}
+
+	if maxInFlight > 2 {
+		t.Fatalf("%d calls in flight, want 2 at most", maxInFlight)
+	}
+
+	if err := FirstError(errs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFanOutCancelOnError(t *testing.T) {
+	rootErr := errors.New("boom")
+
+	// the failing call is the last one, its siblings at lower indexes are canceled by it
+	reqs := []int{0, 1, 2, 3}
+	_, errs := FanOut(context.Background(), reqs, FanOutOptions{Concurrency: 4, CancelOnError: true},
+		func(ctx context.Context, i int) (int, error) {
+			if i == len(reqs)-1 {
+				time.Sleep(10 * time.Millisecond)
+				return 0, rootErr
+			}
+
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+
+	if err := FirstError(errs); err != rootErr {
+		t.Fatalf("FirstError %v, want %v", err, rootErr)
+	}
+
+	for i, err := range errs[:len(reqs)-1] {
+		var canceledErr *FanOutCanceledError
+		if !errors.As(err, &canceledErr) || canceledErr.Cause != rootErr || !errors.Is(err, context.Canceled) {
+			t.Fatalf("errs[%d] %v, want FanOutCanceledError of %v", i, err, rootErr)
+		}
+	}
+}
+
+func TestFanOutCancelOnErrorSkipsCallsLeft(t *testing.T) {
+	var calls int32
+	_, errs := FanOut(context.Background(), []int{0, 1, 2, 3}, FanOutOptions{Concurrency: 1, CancelOnError: true},
+		func(ctx context.Context, i int) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, errors.New("boom")
+		})
+
+	if calls != 1 {
+		t.Fatalf("%d calls, want 1", calls)
+	}
+
+	for i, err := range errs[1:] {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("errs[%d] %v, want canceled", i+1, err)
+		}
+	}
+}
+
+func TestFanOutWaitsForFailFastRateLimiter(t *testing.T) {
+	srv, calls := flakyServer(t, 0)
+	rest := retryingRest(srv, RetryPolicy{})
+	rest.limiter = newRateLimiter("fanout", &rateLimitConfig{QPS: 50, Burst: 1, Mode: RateLimitFailFast})
+
+	get := func(ctx context.Context, path string) (rsp struct{}, err error) {
+		err = rest.JsonWithContext(ctx, http.MethodGet, path, nil, &rsp)
+		return
+	}
+
+	_, errs := FanOut(context.Background(), []string{"a", "b", "c"}, FanOutOptions{}, get)
+	if err := FirstError(errs); err != nil {
+		t.Fatal(err)
+	}
+
+	if *calls != 3 {
+		t.Fatalf("%d calls upstream, want 3", *calls)
+	}
+
+	// a single call still fails fast
+	_, err := get(context.Background(), "d")
+
+	var limitedErr *RateLimitedError
+	if !errors.As(err, &limitedErr) {
+		t.Fatalf("err %v, want RateLimitedError", err)
+	}
+}
@@ -0,0 +1,112 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go-cygnus/utils/metrics"
+)
+
+// unknownRoute labels calls without WithRoute, concrete paths are never used as label
+const unknownRoute = "other"
+
+var (
+	clientLabels = []string{"client", "method", "route", "status"}
+
+	clientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Outbound http calls, retries of a call are counted once.",
+	}, clientLabels)
+
+	clientErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "client",
+		Name:      "errors_total",
+		Help:      "Failed outbound http calls, status is the http code or the failure kind.",
+	}, clientLabels)
+
+	clientLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of outbound http calls including retries.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, clientLabels)
+)
+
+func init() {
+	metrics.MustRegister(clientRequests, clientErrors, clientLatency)
+}
+
+type routeContextKey struct{}
+
+// WithRoute labels calls made with ctx by a route template, e.g. envs/{env}/apps/{app_id}
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeContextKey{}, route)
+}
+
+func routeFromContext(ctx context.Context) string {
+	if route, ok := ctx.Value(routeContextKey{}).(string); ok && route != "" {
+		return route
+	}
+
+	return unknownRoute
+}
+
+// observe records one call of b
+func (b *baseRest) observe(ctx context.Context, method string, httpCode int, err error, cost time.Duration) {
+	labels := prometheus.Labels{
+		"client": b.clientName(),
+		"method": method,
+		"route":  routeFromContext(ctx),
+		"status": statusLabel(httpCode, err),
+	}
+
+	clientRequests.With(labels).Inc()
+	clientLatency.With(labels).Observe(cost.Seconds())
+	if err != nil {
+		clientErrors.With(labels).Inc()
+	}
+}
+
+// clientName labels b, clients not declared in clients.yml go by host
+func (b *baseRest) clientName() string {
+	if b.Name != "" {
+		return b.Name
+	}
+
+	return b.Host
+}
+
+// statusLabel is the http code, or why no response was received
+func statusLabel(httpCode int, err error) string {
+	if httpCode != 0 {
+		return strconv.Itoa(httpCode)
+	}
+
+	var (
+		circuitErr *CircuitOpenError
+		limitErr   *RateLimitedError
+	)
+
+	switch {
+	case err == nil:
+		return "0"
+	case errors.As(err, &circuitErr):
+		return "circuit_open"
+	case errors.As(err, &limitErr):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+
+	return "error"
+}
@@ -0,0 +1,123 @@
+package clients
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitBlock    = "block"
+	RateLimitFailFast = "fail_fast"
+)
+
+// rateLimitConfig is the clients.yml form of a client side token bucket
+type rateLimitConfig struct {
+	QPS float64 `yaml:"qps" validate:"gt=0"`
+	// Burst defaults to qps rounded up
+	Burst int `yaml:"burst" validate:"min=0"`
+	// Mode is block (default) or fail_fast
+	Mode string `yaml:"mode" validate:"omitempty,oneof=block fail_fast"`
+}
+
+// rateLimiter is a token bucket shared by every copy of a client
+type rateLimiter struct {
+	name     string
+	failFast bool
+
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(name string, conf *rateLimitConfig) *rateLimiter {
+	burst := float64(conf.Burst)
+	if burst == 0 {
+		burst = math.Ceil(conf.QPS)
+	}
+
+	return &rateLimiter{
+		name:     name,
+		failFast: conf.Mode == RateLimitFailFast,
+		rate:     conf.QPS,
+		burst:    burst,
+		tokens:   burst,
+		last:     time.Now(),
+	}
+}
+
+func (r *rateLimiter) advance(now time.Time) {
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+}
+
+// reserve takes a token, it returns how long to wait until the token is really available.
+// In fail fast mode nothing is taken if a wait is needed.
+func (r *rateLimiter) reserve(failFast bool) (wait time.Duration, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(time.Now())
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0, true
+	}
+
+	wait = time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+	if failFast {
+		return wait, false
+	}
+
+	r.tokens--
+
+	return wait, true
+}
+
+// cancel gives back a token reserved but not used
+func (r *rateLimiter) cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(time.Now())
+	r.tokens = math.Min(r.burst, r.tokens+1)
+}
+
+// Wait blocks until a request is allowed, or fails fast with RateLimitedError unless ctx asks to block,
+// the wait is returned for logging
+func (r *rateLimiter) Wait(ctx context.Context) (wait time.Duration, err error) {
+	wait, ok := r.reserve(r.failFast && !blockOnRateLimit(ctx))
+	if !ok {
+		return wait, &RateLimitedError{Client: r.name, RetryAfter: wait}
+	}
+
+	if wait == 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return
+	case <-ctx.Done():
+		r.cancel()
+		return wait, ctx.Err()
+	}
+}
+
+type rateLimitBlockContextKey struct{}
+
+// withRateLimitBlock makes fail fast limiters wait for calls made with ctx, e.g. bulk calls of FanOut
+func withRateLimitBlock(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitBlockContextKey{}, true)
+}
+
+func blockOnRateLimit(ctx context.Context) bool {
+	block, _ := ctx.Value(rateLimitBlockContextKey{}).(bool)
+	return block
+}
@@ -0,0 +1,110 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterFailFast(t *testing.T) {
+	r := newRateLimiter("test", &rateLimitConfig{QPS: 1, Burst: 2, Mode: RateLimitFailFast})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Wait(ctx); err != nil {
+			t.Fatalf("call %d within burst: %s", i, err)
+		}
+	}
+
+	_, err := r.Wait(ctx)
+
+	var limitedErr *RateLimitedError
+	if !errors.As(err, &limitedErr) || limitedErr.Client != "test" || limitedErr.RetryAfter <= 0 {
+		t.Fatalf("err %v, want RateLimitedError with RetryAfter", err)
+	}
+
+	// bulk calls block instead
+	if _, err = r.Wait(withRateLimitBlock(ctx)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiterBlocks(t *testing.T) {
+	r := newRateLimiter("test", &rateLimitConfig{QPS: 50, Burst: 1})
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := r.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one token of the burst, two refilled at 50 qps
+	if cost := time.Since(start); cost < 30*time.Millisecond {
+		t.Fatalf("3 calls in %s, want 40ms at least", cost)
+	}
+}
+
+func TestRateLimiterCancelGivesTokenBack(t *testing.T) {
+	r := newRateLimiter("test", &rateLimitConfig{QPS: 0.001, Burst: 1})
+
+	if _, err := r.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := r.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v, want deadline exceeded", err)
+	}
+
+	// the canceled wait must not hold back the next token further
+	if r.tokens < -0.01 {
+		t.Fatalf("tokens %f after cancel, want 0", r.tokens)
+	}
+}
+
+func TestRateLimitedCallsSpareBreaker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	limited := &baseRest{
+		Name:    "limited",
+		Scheme:  "http",
+		Host:    host,
+		limiter: newRateLimiter("limited", &rateLimitConfig{QPS: 0.001, Burst: 1, Mode: RateLimitFailFast}),
+	}
+
+	ctx := context.Background()
+	if err := limited.JsonWithContext(ctx, http.MethodGet, "x", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < DefaultBreakerFailureThreshold*2; i++ {
+		err := limited.JsonWithContext(ctx, http.MethodGet, "x", nil, nil)
+
+		var limitedErr *RateLimitedError
+		if !errors.As(err, &limitedErr) {
+			t.Fatalf("err %v, want RateLimitedError", err)
+		}
+	}
+
+	if state := BreakerStateOf(host); state != BreakerClosed {
+		t.Fatalf("breaker %s after local rejections, want closed", state)
+	}
+
+	// other clients of the host are not affected
+	other := &baseRest{Name: "other", Scheme: "http", Host: host}
+	if err := other.JsonWithContext(ctx, http.MethodGet, "x", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,275 @@
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/go-playground/validator.v9"
+	"gopkg.in/yaml.v3"
+)
+
+// endpointConfig is a named upstream under endpoints of clients.yml, e.g.
+//
+//	endpoints:
+//	  apollo:
+//	    scheme: http
+//	    hostname: apollo.example.com
+//	    base_uri: openapi/v1
+//	    token: xxx
+//	    timeout: 10
+//	    retry: {times: 2}
+//	    rate_limit: {qps: 10, burst: 20, mode: block}
+//	    cache: {ttl: 30}
+type endpointConfig struct {
+	Scheme   string `yaml:"scheme" validate:"required,oneof=http https"`
+	Hostname string `yaml:"hostname" validate:"required"`
+	URIBase  string `yaml:"base_uri"`
+	// Token is a shortcut of auth type token, sent as Authorization header
+	Token string `yaml:"token"`
+	// Auth replaces the plain Token when set
+	Auth    *authConfig       `yaml:"auth"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout int               `yaml:"timeout" validate:"min=0"` // seconds, 0 uses DefaultReqTimeSecond
+	Retry   *retryConfig      `yaml:"retry"`
+	// Trace logs dns, connect, tls and first byte cost of every call
+	Trace bool `yaml:"trace"`
+	// CircuitBreaker overrides clientsConfig.CircuitBreaker
+	CircuitBreaker *breakerConfig `yaml:"circuit_breaker"`
+	// RateLimit enforces the upstream quota on this side, no limit if not set
+	RateLimit *rateLimitConfig `yaml:"rate_limit"`
+	// Cache keeps GET responses, WithCacheTTL overrides it per call
+	Cache *cacheConfig `yaml:"cache"`
+	// Transport gives the endpoint connections of its own, others share one pool
+	Transport *transportConfig `yaml:"transport"`
+}
+
+// retryConfig is the clients.yml form of RetryPolicy
+type retryConfig struct {
+	Times       int   `yaml:"times" validate:"min=0"`
+	BaseDelayMs int   `yaml:"base_delay_ms" validate:"min=0"`
+	MaxDelayMs  int   `yaml:"max_delay_ms" validate:"min=0"`
+	Status      []int `yaml:"status"`
+	// NonIdempotent opts POST and PATCH in retries, only safe if the upstream dedupes them
+	NonIdempotent bool `yaml:"non_idempotent"`
+}
+
+// apply overrides retry fields of conf, nil config keeps conf untouched
+func (r *retryConfig) apply(conf *baseConfig) {
+	if r == nil {
+		return
+	}
+
+	conf.RetryTimes = r.Times
+	conf.Retry.BaseDelay = time.Duration(r.BaseDelayMs) * time.Millisecond
+	conf.Retry.MaxDelay = time.Duration(r.MaxDelayMs) * time.Millisecond
+	conf.Retry.RetryableStatus = r.Status
+	conf.Retry.NonIdempotent = r.NonIdempotent
+}
+
+// build turns the endpoint into a ready-to-use client, global supplies defaults
+func (e *endpointConfig) build(name string, global *clientsConfig) (rest *baseRest, err error) {
+	conf := defaultHTTPConfig
+	conf.Timeout = e.Timeout
+	conf.Trace = e.Trace
+	conf.Breaker = global.breakerConfig(e.CircuitBreaker)
+	if e.Cache != nil {
+		conf.CacheTTL = time.Duration(e.Cache.TTL) * time.Second
+	}
+	e.Retry.apply(&conf)
+
+	// never share the map with defaultHTTPConfig or other endpoints
+	conf.Headers = make(map[string]string, len(e.Headers))
+	for k, v := range e.Headers {
+		conf.Headers[k] = v
+	}
+
+	rest = &baseRest{
+		Name:    name,
+		Scheme:  e.Scheme,
+		Host:    e.Hostname,
+		URIBase: strings.Trim(e.URIBase, "/"),
+		Config:  conf,
+	}
+
+	if e.RateLimit != nil {
+		rest.limiter = newRateLimiter(name, e.RateLimit)
+	}
+
+	if e.Transport != nil {
+		var transport *http.Transport
+		if transport, err = e.Transport.newTransport(); err != nil {
+			return nil, err
+		}
+		rest.client = &http.Client{Transport: transport}
+	}
+
+	switch {
+	case e.Auth != nil:
+		rest.Auth, err = e.Auth.NewAuthenticator(rest.client)
+	case e.Token != "":
+		rest.Auth = &TokenAuth{Token: e.Token}
+	}
+
+	return
+}
+
+// ConfigError lists every invalid entry of clients.yml
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid restclient config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+var (
+	registry     = make(map[string]*baseRest)
+	registryLock sync.RWMutex
+)
+
+// Get returns a copy of the client declared as name under endpoints of clients.yml
+func Get(name string) *baseRest {
+	rest, ok := Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("no %s client config", name))
+	}
+
+	return rest
+}
+
+// Lookup is Get without panic
+func Lookup(name string) (*baseRest, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	registered, ok := registry[name]
+	if !ok {
+		return nil, false
+	}
+
+	rest := *registered
+	rest.Config.Headers = make(map[string]string, len(registered.Config.Headers))
+	for k, v := range registered.Config.Headers {
+		rest.Config.Headers[k] = v
+	}
+
+	return &rest, true
+}
+
+// Register adds or replaces a client by name, e.g. a test pointing a client to a fake server
+func Register(name string, rest *baseRest) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[name] = rest
+}
+
+// load parses and validates clients.yml, every problem is reported instead of the first one
+func load(content []byte) (conf clientsConfig, clients map[string]*baseRest, err error) {
+	if err = yaml.Unmarshal(content, &conf); err != nil {
+		err = fmt.Errorf("unmarshal restclient content error: %s", err)
+		return
+	}
+
+	var problems []string
+
+	if conf.LegacyApollo != nil {
+		if _, ok := conf.Endpoints[apolloEndpoint]; ok {
+			problems = append(problems, "apollo: also declared as endpoints.apollo, remove the legacy key")
+		} else {
+			logger.Warn("clients.yml apollo is deprecated, move it under endpoints")
+
+			if conf.Endpoints == nil {
+				conf.Endpoints = make(map[string]*endpointConfig)
+			}
+			conf.Endpoints[apolloEndpoint] = conf.LegacyApollo
+		}
+	}
+
+	validate := validator.New()
+	// report fields as they are in clients.yml
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		if name := strings.SplitN(field.Tag.Get("yaml"), ",", 2)[0]; name != "" && name != "-" {
+			return name
+		}
+
+		return field.Name
+	})
+
+	if vErr := validate.Struct(&conf); vErr != nil {
+		problems = append(problems, validationProblems("", vErr)...)
+	}
+
+	names := make([]string, 0, len(conf.Endpoints))
+	for name := range conf.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clients = make(map[string]*baseRest, len(names))
+	for _, name := range names {
+		endpoint := conf.Endpoints[name]
+		if endpoint == nil {
+			problems = append(problems, fmt.Sprintf("endpoints.%s: empty", name))
+			continue
+		}
+
+		if vErr := validate.Struct(endpoint); vErr != nil {
+			problems = append(problems, validationProblems("endpoints."+name, vErr)...)
+			continue
+		}
+
+		rest, buildErr := endpoint.build(name, &conf)
+		if buildErr != nil {
+			problems = append(problems, fmt.Sprintf("endpoints.%s: %s", name, buildErr))
+			continue
+		}
+
+		clients[name] = rest
+	}
+
+	if consumer := conf.ApolloConsumerConfig; consumer != nil {
+		endpointName := consumer.Endpoint
+		if endpointName == "" {
+			endpointName = DefaultApolloConsumerEndpoint
+		}
+
+		if _, ok := conf.Endpoints[endpointName]; !ok {
+			problems = append(problems, fmt.Sprintf("apollo_config.endpoint: no endpoints.%s declared", endpointName))
+		}
+	}
+
+	if len(problems) > 0 {
+		err = &ConfigError{Problems: problems}
+	}
+
+	return
+}
+
+func validationProblems(prefix string, err error) (problems []string) {
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []string{fmt.Sprintf("%s: %s", prefix, err)}
+	}
+
+	for _, fieldErr := range validationErrors {
+		// drop the struct name, Namespace is like endpointConfig.retry.times
+		field := fieldErr.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+
+		problems = append(problems, fmt.Sprintf("%s: failed on '%s' %s", field, fieldErr.Tag(), fieldErr.Param()))
+	}
+
+	return
+}
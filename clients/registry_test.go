@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoadEndpoints(t *testing.T) {
+	_, clients, err := load([]byte(`
+endpoints:
+  apollo:
+    scheme: https
+    hostname: apollo.example.com
+    token: t1
+    retry: {times: 2}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest := clients["apollo"]
+	if rest == nil || rest.Host != "apollo.example.com" || rest.Config.RetryTimes != 2 {
+		t.Fatalf("unexpected client %+v", rest)
+	}
+	if auth, ok := rest.Auth.(*TokenAuth); !ok || auth.Token != "t1" {
+		t.Fatalf("unexpected auth %+v", rest.Auth)
+	}
+}
+
+func TestLoadLegacyApollo(t *testing.T) {
+	_, clients, err := load([]byte(`
+apollo:
+  scheme: http
+  hostname: apollo.example.com
+  token: t1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest := clients["apollo"]
+	if rest == nil || rest.Host != "apollo.example.com" {
+		t.Fatalf("legacy apollo not read, clients %+v", clients)
+	}
+	if auth, ok := rest.Auth.(*TokenAuth); !ok || auth.Token != "t1" {
+		t.Fatalf("unexpected auth %+v", rest.Auth)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, _, err := load([]byte(`
+apollo:
+  scheme: http
+  hostname: legacy.example.com
+endpoints:
+  apollo:
+    scheme: ftp
+    hostname: apollo.example.com
+  other:
+    scheme: http
+apollo_config:
+  app_id: app
+  endpoint: config_service
+`))
+
+	var confErr *ConfigError
+	if !errors.As(err, &confErr) {
+		t.Fatalf("err %v, want *ConfigError", err)
+	}
+
+	for _, want := range []string{"apollo:", "endpoints.apollo.scheme", "endpoints.other.hostname", "apollo_config.endpoint"} {
+		found := false
+		for _, problem := range confErr.Problems {
+			found = found || strings.HasPrefix(problem, want)
+		}
+		if !found {
+			t.Errorf("no problem of %s in %q", want, confErr.Problems)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 5 * time.Second
+)
+
+// DefaultRetryableStatus are the upstream status codes retried when a client does not override them
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy decides whether and when a failed request is sent again.
+// RetryTimes in baseConfig is the number of extra attempts after the first one.
+type RetryPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// RetryableStatus overrides DefaultRetryableStatus when not empty
+	RetryableStatus []int
+	// RetryableError overrides the default network error check when not nil
+	RetryableError func(err error) bool
+	// NonIdempotent retries POST and PATCH too, they may be applied twice by the upstream
+	NonIdempotent bool
+}
+
+var (
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterLock sync.Mutex
+)
+
+// Backoff returns exponential backoff with full jitter for the given retry attempt, starting from 1
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+
+	backoff := maxDelay
+	if attempt < 32 {
+		if d := base << uint(attempt-1); d > 0 && d < maxDelay {
+			backoff = d
+		}
+	}
+
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+
+	return time.Duration(jitterRand.Int63n(int64(backoff) + 1))
+}
+
+// ShouldRetryStatus reports whether the upstream http code is retryable
+func (p *RetryPolicy) ShouldRetryStatus(code int) bool {
+	retryable := p.RetryableStatus
+	if len(retryable) == 0 {
+		retryable = DefaultRetryableStatus
+	}
+
+	for _, c := range retryable {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ShouldRetryRequest reports whether req may be sent again, only idempotent methods are by default,
+// as net/http does a request with Idempotency-Key header counts as idempotent
+func (p *RetryPolicy) ShouldRetryRequest(req *http.Request) bool {
+	if p.NonIdempotent {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	_, hasKey := req.Header["Idempotency-Key"]
+	_, hasXKey := req.Header["X-Idempotency-Key"]
+
+	return hasKey || hasXKey
+}
+
+type noRetryContextKey struct{}
+
+// WithoutRetry sends requests made with ctx once, e.g. actions an upstream may have applied before failing
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryContextKey{}, true)
+}
+
+func noRetry(ctx context.Context) bool {
+	disabled, _ := ctx.Value(noRetryContextKey{}).(bool)
+	return disabled
+}
+
+// ShouldRetryError reports whether an error returned by http.Client.Do is retryable
+func (p *RetryPolicy) ShouldRetryError(err error) bool {
+	if p.RetryableError != nil {
+		return p.RetryableError(err)
+	}
+
+	return isTemporaryNetError(err)
+}
+
+func isTemporaryNetError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// *url.Error is a net.Error itself, check what it wraps
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,118 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers 502 failures times before 200, calls counts the requests served
+func flakyServer(t *testing.T, failures int32) (srv *httptest.Server, calls *int32) {
+	calls = new(int32)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return
+}
+
+func retryingRest(srv *httptest.Server, policy RetryPolicy) *baseRest {
+	policy.BaseDelay = time.Millisecond
+
+	return &baseRest{
+		Name:   "retry",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+		Config: baseConfig{
+			RetryTimes: 2,
+			Retry:      policy,
+			Breaker:    &breakerConfig{Disabled: true},
+		},
+	}
+}
+
+func TestRetryIdempotentMethods(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		srv, calls := flakyServer(t, 2)
+
+		err := retryingRest(srv, RetryPolicy{}).JsonWithContext(context.Background(), method, "x", nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+		if *calls != 3 {
+			t.Errorf("%s: %d calls, want 3", method, *calls)
+		}
+	}
+}
+
+func TestRetrySkipsNonIdempotentMethods(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPatch} {
+		srv, calls := flakyServer(t, 2)
+
+		err := retryingRest(srv, RetryPolicy{}).JsonWithContext(context.Background(), method, "x", nil, nil)
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+			t.Fatalf("%s: err %v, want http 502", method, err)
+		}
+		if *calls != 1 {
+			t.Errorf("%s: %d calls, want 1", method, *calls)
+		}
+	}
+}
+
+func TestRetryNonIdempotentOptIn(t *testing.T) {
+	srv, calls := flakyServer(t, 2)
+
+	err := retryingRest(srv, RetryPolicy{NonIdempotent: true}).
+		JsonWithContext(context.Background(), http.MethodPost, "x", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 3 {
+		t.Errorf("%d calls, want 3", *calls)
+	}
+}
+
+func TestRetryIdempotencyKey(t *testing.T) {
+	srv, calls := flakyServer(t, 1)
+	rest := retryingRest(srv, RetryPolicy{})
+
+	req, err := rest.Request(http.MethodPost, srv.URL+"/x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", "k1")
+
+	if _, err = rest.DoWithContext(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 2 {
+		t.Errorf("%d calls, want 2", *calls)
+	}
+}
+
+func TestWithoutRetry(t *testing.T) {
+	srv, calls := flakyServer(t, 2)
+	rollbacks := &apollo{baseRest: *retryingRest(srv, RetryPolicy{})}
+
+	err := rollbacks.RollbackRelease(context.Background(), RollbackReleaseReq{Env: "DEV", ReleaseID: 1, Operator: "ops"})
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err %v, want http 502", err)
+	}
+	if *calls != 1 {
+		t.Errorf("rollback sent %d times, want once", *calls)
+	}
+}
@@ -0,0 +1,155 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.elastic.co/apm"
+
+	"go-cygnus/utils/logging"
+)
+
+const (
+	HeaderReqID       = "X-Request-ID"
+	HeaderTraceParent = "traceparent"
+)
+
+var traceParentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// propagate forwards the request id and W3C trace context bound to ctx, headers set by caller are kept.
+// req.Header is replaced by a copy, a request reused by the caller must not keep the ids of this call
+func propagate(ctx context.Context, req *http.Request) {
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	if reqID := logging.ReqIDFromContext(ctx); reqID != "" && header.Get(HeaderReqID) == "" {
+		header.Set(HeaderReqID, reqID)
+	}
+
+	if header.Get(HeaderTraceParent) == "" {
+		if traceParent := outboundTraceParent(ctx); traceParent != "" {
+			header.Set(HeaderTraceParent, traceParent)
+		}
+	}
+
+	req.Header = header
+}
+
+// outboundTraceParent prefers the apm span or transaction in ctx, then continues the inbound traceparent
+// with a new parent id, the trace is not started here if neither exists
+func outboundTraceParent(ctx context.Context) string {
+	var traceContext *apm.TraceContext
+	if span := apm.SpanFromContext(ctx); span != nil {
+		tc := span.TraceContext()
+		traceContext = &tc
+	} else if tx := apm.TransactionFromContext(ctx); tx != nil {
+		tc := tx.TraceContext()
+		traceContext = &tc
+	}
+
+	if traceContext != nil {
+		var flags byte
+		if traceContext.Options.Recorded() {
+			flags = 1
+		}
+
+		return fmt.Sprintf("00-%s-%s-%02x", traceContext.Trace, traceContext.Span, flags)
+	}
+
+	matched := traceParentPattern.FindStringSubmatch(logging.TraceParentFromContext(ctx))
+	if matched == nil {
+		return ""
+	}
+
+	var parentID [8]byte
+	if _, err := rand.Read(parentID[:]); err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", matched[1], matched[2], hex.EncodeToString(parentID[:]), matched[4])
+}
+
+// requestTrace collects the http lifecycle of one attempt when baseConfig.Trace is on
+type requestTrace struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{start: time.Now()}
+}
+
+func (t *requestTrace) set(field *time.Time) {
+	t.mu.Lock()
+	*field = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart: func(string, string) {
+			// dual stack dials several addresses, keep the first start
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone:          func(string, string, error) { t.set(&t.connectDone) },
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+	}
+}
+
+func since(from time.Time, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+
+	return to.Sub(from).Seconds()
+}
+
+// fields is the breakdown in seconds, a phase skipped e.g. by a reused connection is 0
+func (t *requestTrace) fields() logrus.Fields {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return logrus.Fields{
+		"trace_dns":        since(t.dnsStart, t.dnsDone),
+		"trace_connect":    since(t.connectStart, t.connectDone),
+		"trace_tls":        since(t.tlsStart, t.tlsDone),
+		"trace_first_byte": since(t.gotConn, t.firstByte),
+		"trace_total":      since(t.start, t.firstByte),
+		"trace_reused":     t.reused,
+	}
+}
@@ -0,0 +1,64 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-cygnus/utils/logging"
+)
+
+func TestPropagateHeaders(t *testing.T) {
+	var received []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Clone())
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	rest := &baseRest{
+		Name:   "trace",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+		Config: baseConfig{Breaker: &breakerConfig{Disabled: true}, Trace: true},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const inbound = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	for _, reqID := range []string{"r1", "r2"} {
+		ctx := logging.ContextWithTraceParent(logging.ContextWithReqID(context.Background(), reqID), inbound)
+		if _, err = rest.DoWithContext(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(req.Header) != 0 {
+		t.Fatalf("header of the caller modified: %v", req.Header)
+	}
+
+	for i, reqID := range []string{"r1", "r2"} {
+		if got := received[i].Get(HeaderReqID); got != reqID {
+			t.Errorf("call %d request id %q, want %s", i, got, reqID)
+		}
+
+		traceParent := received[i].Get(HeaderTraceParent)
+		if !strings.HasPrefix(traceParent, "00-0af7651916cd43dd8448eb211c80319c-") || strings.Contains(traceParent, "b7ad6b7169203331") {
+			t.Errorf("call %d traceparent %q, want the inbound trace with a new parent id", i, traceParent)
+		}
+	}
+
+	// ids set by the caller are kept
+	req.Header.Set(HeaderReqID, "own")
+	if _, err = rest.DoWithContext(logging.ContextWithReqID(context.Background(), "r3"), req); err != nil {
+		t.Fatal(err)
+	}
+	if got := received[2].Get(HeaderReqID); got != "own" {
+		t.Errorf("request id %q, want own", got)
+	}
+}
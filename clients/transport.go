@@ -0,0 +1,132 @@
+package clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 100
+)
+
+// transportConfig is the clients.yml form of the connection settings of an endpoint, e.g.
+//
+//	transport:
+//	  max_idle_conns_per_host: 20
+//	  proxy: http://proxy.example.com:3128
+//	  http2: false
+//	  tls: {ca_file: /etc/ssl/apollo-ca.pem, cert_file: client.pem, key_file: client-key.pem}
+type transportConfig struct {
+	MaxIdleConns        int `yaml:"max_idle_conns" validate:"min=0"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host" validate:"min=0"`
+	MaxConnsPerHost     int `yaml:"max_conns_per_host" validate:"min=0"`
+	IdleConnTimeout     int `yaml:"idle_conn_timeout" validate:"min=0"` // seconds
+	// Proxy replaces the HTTP_PROXY and HTTPS_PROXY environments
+	Proxy string `yaml:"proxy" validate:"omitempty,url"`
+	// HTTP2 false sticks to http/1.1, http2 is attempted over tls by default
+	HTTP2 *bool      `yaml:"http2"`
+	TLS   *tlsConfig `yaml:"tls"`
+}
+
+type tlsConfig struct {
+	// CAFile is a pem bundle trusted besides the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate of mutual tls
+	CertFile   string `yaml:"cert_file" validate:"required_with=KeyFile"`
+	KeyFile    string `yaml:"key_file" validate:"required_with=CertFile"`
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify is for dev environments only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// newDefaultTransport copies http.DefaultTransport with a larger connection pool
+func newDefaultTransport() *http.Transport {
+	defaultTransport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		panic("http.DefaultTransport not an *http.Transport")
+	}
+
+	t := defaultTransport.Clone()
+	t.MaxIdleConns = DefaultMaxIdleConns
+	t.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+
+	return t
+}
+
+// newTransport builds a transport of its own for an endpoint, unset fields keep newDefaultTransport values
+func (c *transportConfig) newTransport() (t *http.Transport, err error) {
+	t = newDefaultTransport()
+
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = time.Duration(c.IdleConnTimeout) * time.Second
+	}
+
+	if c.Proxy != "" {
+		var proxyURL *url.URL
+		if proxyURL, err = url.Parse(c.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %s", c.Proxy, err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.TLS != nil {
+		if t.TLSClientConfig, err = c.TLS.build(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.HTTP2 != nil && !*c.HTTP2 {
+		// a non-nil empty TLSNextProto is how net/http turns http2 off
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return
+}
+
+func (c *tlsConfig) build() (conf *tls.Config, err error) {
+	conf = &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(c.CAFile); err != nil {
+			return nil, fmt.Errorf("read tls ca_file error: %s", err)
+		}
+
+		if conf.RootCAs, err = x509.SystemCertPool(); err != nil || conf.RootCAs == nil {
+			conf.RootCAs = x509.NewCertPool()
+		}
+
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in tls ca_file %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			return nil, fmt.Errorf("load tls client certificate error: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
@@ -1,25 +1,107 @@
 package dto
 
 import (
+	"context"
+
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
 
 	"go-cygnus/clients"
 	"go-cygnus/models"
+	"go-cygnus/models/repo"
 	"go-cygnus/utils/db"
 )
 
-type ListAccountReq struct{}
+type ListAccountReq struct {
+	// Unscoped lists deleted accounts too
+	Unscoped bool `form:"unscoped"`
+}
 
 type ListAccountRsp struct {
 	PagedRsp
 	Result []models.Account `json:"result"`
 }
 
-func (dto *ListAccountReq) List(pagination Pagination) (rsp ListAccountRsp, err error) {
+func (dto *ListAccountReq) List(ctx context.Context, pagination Pagination) (rsp ListAccountRsp, err error) {
 	rsp.FillPagination(pagination)
 
-	err = db.Engine.Model(&models.Account{}).Count(&rsp.Count).Offset(
-		pagination.Offset).Limit(pagination.Limit).Find(&rsp.Result).Error
+	rsp.Result, rsp.Count, err = repo.New[models.Account]().List(ctx, repo.Query{
+		Offset:   pagination.Offset,
+		Limit:    pagination.Limit,
+		Unscoped: dto.Unscoped,
+	})
+	return
+}
+
+type DeleteAccountReq struct {
+	ID uint `uri:"id" binding:"required"`
+}
+
+// Delete soft deletes the account, it can be restored later
+func (dto *DeleteAccountReq) Delete(ctx context.Context) error {
+	return repo.New[models.Account]().Delete(ctx, dto.ID)
+}
+
+type RestoreAccountReq struct {
+	ID uint `uri:"id" binding:"required"`
+}
+
+func (dto *RestoreAccountReq) Restore(ctx context.Context) (rsp models.Account, err error) {
+	accounts := repo.New[models.Account]()
+
+	if err = accounts.Restore(ctx, dto.ID); err != nil {
+		return
+	}
+
+	// read own write from primary, replicas may lag behind
+	rsp, err = accounts.WithTx(db.Primary(db.FromContext(ctx))).Get(ctx, dto.ID)
+	return
+}
+
+type UpdateAccountReq struct {
+	ID    uint    `uri:"id" json:"-" binding:"required"`
+	Key   *string `json:"key"`
+	Value *string `json:"value"`
+	// Version read by the client, the update fails with *db.ConflictError if the account changed since.
+	// If-Match header takes precedence, the version loaded here is checked if neither is given
+	Version db.Version `json:"version"`
+}
+
+// Update saves the given fields of the account
+func (dto *UpdateAccountReq) Update(ctx context.Context) (rsp models.Account, err error) {
+	accounts := repo.New[models.Account]()
+
+	err = db.WithTx(ctx, func(tx *gorm.DB) error {
+		txCtx := tx.Statement.Context
+		if rsp, err = accounts.Get(txCtx, dto.ID); err != nil {
+			return err
+		}
+
+		fields := make([]string, 0, 2)
+		if dto.Key != nil {
+			rsp.Key = *dto.Key
+			fields = append(fields, "key")
+		}
+		if dto.Value != nil {
+			rsp.Value = *dto.Value
+			fields = append(fields, "value")
+		}
+
+		if len(fields) == 0 {
+			// nothing to save, still a stale version is rejected
+			if dto.Version != 0 && dto.Version != rsp.Version {
+				return &db.ConflictError{Table: "accounts", ID: dto.ID, Version: dto.Version}
+			}
+			return nil
+		}
+
+		if dto.Version != 0 {
+			rsp.Version = dto.Version
+		}
+
+		return accounts.Update(txCtx, &rsp, fields...)
+	})
+
 	return
 }
 
@@ -32,14 +114,14 @@ type AddAccountReq struct {
 
 type AddAccountRsp struct{}
 
-func (dto *AddAccountReq) Add() (rsp AddAccountRsp, err error) {
+func (dto *AddAccountReq) Add(ctx context.Context) (rsp AddAccountRsp, err error) {
 	var apolloReq clients.GetNamespaceInfoReq
 
 	if err = copier.Copy(&apolloReq, dto); err != nil {
 		return
 	}
 
-	if _, err = clients.Apollo().GetNamespaceInfo(apolloReq); err != nil {
+	if _, err = clients.Apollo().GetNamespaceInfo(ctx, apolloReq); err != nil {
 		return
 	}
 
@@ -0,0 +1,51 @@
+package dto
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"go-cygnus/models"
+	"go-cygnus/utils/db"
+)
+
+func useTestEngine(t *testing.T) {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.AutoMigrate(&models.Account{}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := db.Engine
+	db.Engine = engine
+	t.Cleanup(func() {
+		db.Engine = saved
+	})
+}
+
+func TestUpdateAccountWithoutFields(t *testing.T) {
+	useTestEngine(t)
+	ctx := context.Background()
+
+	if err := db.Engine.Create(&models.Account{Key: "k"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := (&UpdateAccountReq{ID: 1, Version: 1}).Update(ctx)
+	if err != nil || rsp.Version != 1 {
+		t.Fatalf("version %d err %v, want 1", rsp.Version, err)
+	}
+
+	_, err = (&UpdateAccountReq{ID: 1, Version: 5}).Update(ctx)
+
+	var conflictErr *db.ConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Version != 5 {
+		t.Fatalf("err %v, want ConflictError of version 5", err)
+	}
+}
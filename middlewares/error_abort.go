@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
@@ -12,7 +13,9 @@ import (
 	"gopkg.in/go-playground/validator.v9"
 	"gorm.io/gorm"
 
+	"go-cygnus/clients"
 	"go-cygnus/constants"
+	"go-cygnus/utils/db"
 	"go-cygnus/utils/logging"
 	"go-cygnus/utils/validators"
 )
@@ -54,6 +57,32 @@ func (w *APIError) Code() int {
 		return http.StatusNotFound
 	}
 
+	var circuitOpenErr *clients.CircuitOpenError
+	if errors.As(w.Origin, &circuitOpenErr) {
+		return http.StatusServiceUnavailable
+	}
+
+	var rateLimitedErr *clients.RateLimitedError
+	if errors.As(w.Origin, &rateLimitedErr) {
+		return http.StatusTooManyRequests
+	}
+
+	// a bad upstream payload is not the fault of our caller
+	var invalidRspErr *clients.InvalidResponseError
+	if errors.As(w.Origin, &invalidRspErr) {
+		return http.StatusBadGateway
+	}
+
+	var conflictErr *db.ConflictError
+	if errors.As(w.Origin, &conflictErr) {
+		return http.StatusConflict
+	}
+
+	var upstreamErr *clients.HTTPError
+	if errors.As(w.Origin, &upstreamErr) && upstreamErr.StatusCode == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+
 	if w.AsHTTPCode != 0 {
 		return w.AsHTTPCode
 	}
@@ -75,6 +104,13 @@ func APINormalErrorHandler(logger *logging.ConvenientErrorLogger) gin.HandlerFun
 			reqID := uuid.New()
 			c.Set(ContextKeyReqID, reqID)
 
+			// outbound calls made with c.Request.Context() forward them
+			ctx := logging.ContextWithReqID(c.Request.Context(), reqID)
+			if traceParent := c.GetHeader(clients.HeaderTraceParent); traceParent != "" {
+				ctx = logging.ContextWithTraceParent(ctx, traceParent)
+			}
+			c.Request = c.Request.WithContext(ctx)
+
 			uri := c.Request.URL.RequestURI()
 			l := logger.WithFields(traceContextFields).WithField(ContextKeyReqID, reqID).WithField("uri", uri)
 
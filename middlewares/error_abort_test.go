@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+	"testing"
+
+	"gopkg.in/go-playground/validator.v9"
+
+	"go-cygnus/clients"
+)
+
+func TestAPIErrorCode(t *testing.T) {
+	for _, c := range []struct {
+		origin error
+		code   int
+	}{
+		{validator.ValidationErrors{}, http.StatusBadRequest},
+		{&clients.InvalidResponseError{Err: validator.ValidationErrors{}}, http.StatusBadGateway},
+		{&clients.HTTPError{StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{&clients.RateLimitedError{}, http.StatusTooManyRequests},
+	} {
+		if got := (&APIError{Origin: c.origin}).Code(); got != c.code {
+			t.Errorf("%T code %d, want %d", c.origin, got, c.code)
+		}
+	}
+}
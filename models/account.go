@@ -2,6 +2,7 @@ package models
 
 type Account struct {
 	BaseModel
+	Versioned
 	Key string `json:"key"`
 	Value string `json:"value"`
 }
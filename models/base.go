@@ -6,5 +6,14 @@ type BaseModel struct {
 	ID        uint         `json:"id" gorm:"primary_key"`
 	CreatedAt db.JSONTime  `json:"created_at"`
 	UpdatedAt db.JSONTime  `json:"updated_at"`
-	DeletedAt *db.JSONTime `sql:"index" json:"deleted_at"`
+	DeletedAt db.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// Versioned opts a model in optimistic locking, stale updates fail with *db.ConflictError
+type Versioned struct {
+	Version db.Version `json:"version" gorm:"not null;default:1"`
+}
+
+func (m BaseModel) PrimaryKey() uint {
+	return m.ID
 }
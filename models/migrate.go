@@ -1,31 +1,40 @@
 package models
 
 import (
+	"context"
 	"flag"
 	"os"
 
 	"go-cygnus/utils/db"
+	"go-cygnus/utils/db/migrate"
 	"go-cygnus/utils/logging"
 )
 
-var syncDB bool
+var (
+	syncDB         bool
+	migrateCommand string
+)
 
 func init() {
-	flag.BoolVar(&syncDB, "syncdb", false, "start app with initializing database")
+	flag.BoolVar(&syncDB, "syncdb", false, "start app with initializing database, same as -migrate up")
+	flag.StringVar(&migrateCommand, "migrate", "", "run a migrate command and exit: up, down, status or \"to <version>\"")
 }
 
 func SyncDB() {
-	// Sync db models automatically
-	if syncDB {
-		logging.GetLogger("root").Info("running syncdb procedure")
-		tx := db.Engine.Debug()
-
-		err := tx.AutoMigrate(&Action{}, &Account{})
-		if err != nil {
-			logging.GetLogger("root").WithError(err).Error("auto migrate")
+	command := migrateCommand
+	if command == "" && syncDB {
+		command = "up"
+	}
+
+	// Migrate db models on demand
+	if command != "" {
+		logging.GetLogger("root").WithField("command", command).Info("running migrate procedure")
+
+		if err := migrate.New(db.Engine, Migrations...).Run(context.Background(), command); err != nil {
+			logging.GetLogger("root").WithError(err).Fatal("migrate")
 		}
 
-		logging.GetLogger("root").Info("done syncdb, exit")
+		logging.GetLogger("root").Info("done migrate, exit")
 
 		// NOTE: exit will not run any defer
 		os.Exit(0)
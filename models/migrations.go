@@ -0,0 +1,76 @@
+package models
+
+import (
+	"gorm.io/gorm"
+
+	"go-cygnus/utils/db"
+	"go-cygnus/utils/db/migrate"
+)
+
+// Migrations of the models in version order, append new ones and never edit an applied one.
+// They declare the tables as of their version instead of using the models, which keep changing.
+var Migrations = []*migrate.Migration{
+	{
+		// tables created by the former AutoMigrate, it is a no-op on such databases
+		Version: "20210901000000",
+		Name:    "initial",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&initialAction{}, &initialAccount{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&initialAction{}, &initialAccount{})
+		},
+	},
+	{
+		Version: "20211001000000",
+		Name:    "account_version",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&accountVersion{}, "Version")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&accountVersion{}, "Version")
+		},
+	},
+}
+
+type initialBaseModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt db.JSONTime
+	UpdatedAt db.JSONTime
+	DeletedAt db.DeletedAt `gorm:"index"`
+}
+
+type initialAction struct {
+	Base      initialBaseModel `gorm:"embedded"`
+	Client    db.JSON          `sql:"type:json"`
+	Server    db.JSON          `sql:"type:json"`
+	Request   db.JSON          `sql:"type:json"`
+	Response  db.JSON          `sql:"type:json"`
+	Operation string
+	Detail    string
+	Level     int `gorm:"default:1"`
+	Tag       int
+	User      string `gorm:"default:'anonymous'"`
+}
+
+func (initialAction) TableName() string {
+	return "actions"
+}
+
+type initialAccount struct {
+	Base  initialBaseModel `gorm:"embedded"`
+	Key   string
+	Value string
+}
+
+func (initialAccount) TableName() string {
+	return "accounts"
+}
+
+type accountVersion struct {
+	Version db.Version `gorm:"not null;default:1"`
+}
+
+func (accountVersion) TableName() string {
+	return "accounts"
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"go-cygnus/utils/db/migrate"
+)
+
+func TestMigrationsMatchModels(t *testing.T) {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	m := migrate.New(engine, Migrations...)
+	if err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the latest migration brings the tables to the models
+	for _, model := range []interface{}{&Action{}, &Account{}} {
+		s, err := schema.Parse(model, &engine.Statement.Settings, engine.NamingStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, field := range s.Fields {
+			if field.DBName != "" && !engine.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("column %s.%s not created by migrations", s.Table, field.DBName)
+			}
+		}
+	}
+
+	for range Migrations {
+		if err = m.Down(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if engine.Migrator().HasTable(&Account{}) || engine.Migrator().HasTable(&Action{}) {
+		t.Fatal("tables left after rolling back every migration")
+	}
+}
@@ -0,0 +1,287 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"go-cygnus/utils/db"
+)
+
+// Model is satisfied by models embedding models.BaseModel
+type Model interface {
+	PrimaryKey() uint
+}
+
+const softDeleteColumn = "deleted_at"
+
+// filter operators
+const (
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpGt     = "gt"
+	OpGte    = "gte"
+	OpLt     = "lt"
+	OpLte    = "lte"
+	OpLike   = "like"
+	OpIn     = "in"
+	OpIsNull = "null" // Value true for IS NULL, false for IS NOT NULL
+)
+
+var operators = map[string]string{
+	OpEq:   "=",
+	OpNe:   "<>",
+	OpGt:   ">",
+	OpGte:  ">=",
+	OpLt:   "<",
+	OpLte:  "<=",
+	OpLike: "LIKE",
+	OpIn:   "IN",
+}
+
+// Filter is a condition on a column, Field is the column or the go field name of the model
+type Filter struct {
+	Field string
+	Op    string // OpEq if not set
+	Value interface{}
+}
+
+func Eq(field string, value interface{}) Filter {
+	return Filter{Field: field, Op: OpEq, Value: value}
+}
+
+type Query struct {
+	Filters []Filter
+	// Sort are fields, descending if prefixed by -, e.g. -created_at, id by default
+	Sort   []string
+	Offset int
+	Limit  int // no limit if not set or -1
+	// Unscoped includes soft deleted rows
+	Unscoped bool
+}
+
+// Repository is the common data access of model T, queries run in the transaction of db.WithTx
+// found in ctx, or the one given by WithTx
+type Repository[T Model] struct {
+	tx *gorm.DB
+}
+
+func New[T Model]() *Repository[T] {
+	return &Repository[T]{}
+}
+
+// WithTx returns a copy of r running in tx
+func (r *Repository[T]) WithTx(tx *gorm.DB) *Repository[T] {
+	return &Repository[T]{tx: tx}
+}
+
+func (r *Repository[T]) conn(ctx context.Context) *gorm.DB {
+	if r.tx != nil {
+		return r.tx.WithContext(ctx)
+	}
+
+	return db.FromContext(ctx)
+}
+
+// model is the query of T, soft deleted rows are excluded by gorm unless unscoped
+func (r *Repository[T]) model(ctx context.Context, unscoped bool) *gorm.DB {
+	tx := r.conn(ctx).Model(new(T))
+	if unscoped {
+		tx = tx.Unscoped()
+	}
+
+	return tx
+}
+
+// column resolves a field to its column, unknown fields are rejected as they come from requests
+func (r *Repository[T]) column(ctx context.Context, field string) (string, error) {
+	stmt := &gorm.Statement{DB: r.conn(ctx)}
+	if err := stmt.Parse(new(T)); err != nil {
+		return "", err
+	}
+
+	f := stmt.Schema.LookUpField(field)
+	if f == nil || f.DBName == "" {
+		return "", fmt.Errorf("unknown field %s of %s", field, stmt.Schema.Name)
+	}
+
+	return f.DBName, nil
+}
+
+func (r *Repository[T]) applyFilters(ctx context.Context, tx *gorm.DB, filters []Filter) (*gorm.DB, error) {
+	for _, filter := range filters {
+		name, err := r.column(ctx, filter.Field)
+		if err != nil {
+			return nil, err
+		}
+		column := clause.Column{Table: clause.CurrentTable, Name: name}
+
+		op := filter.Op
+		if op == "" {
+			op = OpEq
+		}
+
+		if op == OpIsNull {
+			isNull, _ := filter.Value.(bool)
+			if isNull {
+				tx = tx.Where(clause.Expr{SQL: "? IS NULL", Vars: []interface{}{column}})
+			} else {
+				tx = tx.Where(clause.Expr{SQL: "? IS NOT NULL", Vars: []interface{}{column}})
+			}
+			continue
+		}
+
+		operator, ok := operators[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter operator %s", op)
+		}
+
+		sql := "? " + operator + " ?"
+		if op == OpIn {
+			sql = "? IN (?)"
+		}
+		tx = tx.Where(clause.Expr{SQL: sql, Vars: []interface{}{column, filter.Value}})
+	}
+
+	return tx, nil
+}
+
+// Get finds a row by id, gorm.ErrRecordNotFound if none
+func (r *Repository[T]) Get(ctx context.Context, id uint) (entity T, err error) {
+	err = r.model(ctx, false).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Take(&entity).Error
+	return
+}
+
+// List returns a page of rows and the count of all matched rows
+func (r *Repository[T]) List(ctx context.Context, q Query) (entities []T, count int64, err error) {
+	tx, err := r.applyFilters(ctx, r.model(ctx, q.Unscoped), q.Filters)
+	if err != nil {
+		return
+	}
+
+	if err = tx.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return
+	}
+
+	orders := q.Sort
+	if len(orders) == 0 {
+		orders = []string{"id"}
+	}
+
+	for _, order := range orders {
+		var name string
+		if name, err = r.column(ctx, strings.TrimPrefix(order, "-")); err != nil {
+			return
+		}
+
+		tx = tx.Order(clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: name},
+			Desc:   strings.HasPrefix(order, "-"),
+		})
+	}
+
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	entities = make([]T, 0)
+	err = tx.Find(&entities).Error
+
+	return
+}
+
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return r.conn(ctx).Create(entity).Error
+}
+
+// Update saves fields of entity, zero values included, every non-zero field if no fields are given.
+// A model with db.Version fails with *db.ConflictError if the row changed since its version
+func (r *Repository[T]) Update(ctx context.Context, entity *T, fields ...string) error {
+	tx := r.conn(ctx).Model(entity)
+
+	if len(fields) > 0 {
+		columns := make([]string, 0, len(fields)+1)
+		for _, field := range fields {
+			column, err := r.column(ctx, field)
+			if err != nil {
+				return err
+			}
+			columns = append(columns, column)
+		}
+		tx = tx.Select(append(columns, "updated_at"))
+	}
+
+	return tx.Updates(entity).Error
+}
+
+// Delete soft deletes a row, gorm.ErrRecordNotFound if it does not exist or is already deleted
+func (r *Repository[T]) Delete(ctx context.Context, id uint) error {
+	return r.setDeleted(ctx, id, true)
+}
+
+// Restore brings back a deleted row, gorm.ErrRecordNotFound if it is not deleted
+func (r *Repository[T]) Restore(ctx context.Context, id uint) error {
+	return r.setDeleted(ctx, id, false)
+}
+
+// setDeleted sets or clears deleted_at of a row and bumps its db.Version if any,
+// gorm applies the version clauses neither to soft deletes nor to unscoped updates
+func (r *Repository[T]) setDeleted(ctx context.Context, id uint, deleted bool) error {
+	stmt := &gorm.Statement{DB: r.conn(ctx)}
+	if err := stmt.Parse(new(T)); err != nil {
+		return err
+	}
+
+	cond, columns := "? IS NOT NULL", map[string]interface{}{softDeleteColumn: nil}
+	if deleted {
+		cond, columns[softDeleteColumn] = "? IS NULL", stmt.DB.NowFunc()
+	}
+
+	if field := db.VersionField(stmt.Schema); field != nil {
+		columns[field.DBName] = gorm.Expr("? + 1", clause.Column{Name: field.DBName})
+	}
+
+	tx := r.model(ctx, true).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Where(clause.Expr{
+		SQL:  cond,
+		Vars: []interface{}{clause.Column{Table: clause.CurrentTable, Name: softDeleteColumn}},
+	}).UpdateColumns(columns)
+
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return tx.Error
+}
+
+// Count rows not deleted matching filters
+func (r *Repository[T]) Count(ctx context.Context, filters ...Filter) (count int64, err error) {
+	tx, err := r.applyFilters(ctx, r.model(ctx, false), filters)
+	if err != nil {
+		return
+	}
+
+	err = tx.Count(&count).Error
+
+	return
+}
+
+// Exists tells whether any row not deleted matches filters
+func (r *Repository[T]) Exists(ctx context.Context, filters ...Filter) (bool, error) {
+	tx, err := r.applyFilters(ctx, r.model(ctx, false), filters)
+	if err != nil {
+		return false, err
+	}
+
+	var found []uint
+	if err = tx.Limit(1).Pluck("id", &found).Error; err != nil {
+		return false, err
+	}
+
+	return len(found) > 0, nil
+}
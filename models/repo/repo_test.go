@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"go-cygnus/models"
+	"go-cygnus/utils/db"
+)
+
+func newTestRepo(t *testing.T) *Repository[models.Account] {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.AutoMigrate(&models.Account{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return New[models.Account]().WithTx(engine)
+}
+
+func TestDeleteAndRestoreBumpVersion(t *testing.T) {
+	accounts := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := accounts.Create(ctx, &models.Account{Key: "k", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := accounts.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accounts.Get(ctx, 1); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted account err %v, want not found", err)
+	}
+	if err := accounts.Delete(ctx, 1); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted twice err %v, want not found", err)
+	}
+
+	deleted, _, err := accounts.List(ctx, Query{Unscoped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || !deleted[0].DeletedAt.Valid || deleted[0].Version != 2 {
+		t.Fatalf("deleted %+v, want deleted at version 2", deleted)
+	}
+
+	if err = accounts.Restore(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = accounts.Restore(ctx, 1); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("restored twice err %v, want not found", err)
+	}
+
+	restored, err := accounts.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != db.Version(3) {
+		t.Fatalf("restored version %d, want 3", restored.Version)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	accounts := newTestRepo(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := accounts.Create(ctx, &models.Account{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, count, err := accounts.List(ctx, Query{
+		Filters: []Filter{{Field: "Key", Op: OpIn, Value: []string{"a", "c"}}},
+		Sort:    []string{"-key"},
+		Limit:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(found) != 1 || found[0].Key != "c" {
+		t.Fatalf("found %+v of %d, want c of 2", found, count)
+	}
+
+	if _, _, err = accounts.List(ctx, Query{Filters: []Filter{Eq("password", "x")}}); err == nil {
+		t.Fatal("filtered by an unknown field")
+	}
+}
@@ -1,14 +1,15 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"gorm.io/gorm/logger"
 	"io/ioutil"
+	"time"
 
 	"sigs.k8s.io/yaml"
 
 	"github.com/pkg/errors"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
 	"go-cygnus/constants"
@@ -20,28 +21,113 @@ var (
 )
 
 type Config struct {
+	// Driver is mysql, postgres or sqlite, mysql if not set
+	Driver   string `json:"driver"`
 	Host     string `json:"host"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Database string `json:"database"`
 	Port     string `json:"port"`
+	// Path is the database file of sqlite, an in-memory database if not set
+	Path string `json:"path"`
+	// Params are extra DSN options, they override the ones built from other fields,
+	// e.g. sslmode of postgres or _foreign_keys of sqlite
+	Params map[string]string `json:"params"`
+
+	// pool of the underlying sql.DB, unlimited if not set
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time"`
+
+	// Timeout of dialing, 10s if not set
+	Timeout Duration `json:"timeout"`
+	// ReadTimeout and WriteTimeout are of mysql only
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	// Charset, Collation and Loc are of mysql only, Loc is Local if not set,
+	// Charset is utf8 if neither it nor Collation is set
+	Charset   string     `json:"charset"`
+	Collation string     `json:"collation"`
+	Loc       string     `json:"loc"`
+	TLS       *TLSConfig `json:"tls"`
+
+	// Replicas serve reads of Engine, use Primary to read own writes
+	Replicas     []ReplicaConfig    `json:"replicas"`
+	ReplicaCheck ReplicaCheckConfig `json:"replica_check"`
+
+	Log LogConfig `json:"log"`
 }
 
+// TLSConfig of the database connection
+type TLSConfig struct {
+	// Mode is true, skip-verify or preferred of mysql, or sslmode of postgres, e.g. verify-full
+	Mode string `json:"mode"`
+	// CAFile, CertFile and KeyFile are pem files, CertFile and KeyFile are the client certificate
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	ServerName string `json:"server_name"`
+}
+
+const DefaultDialTimeout = 10 * time.Second
+
 func Init() {
-	dbString := GetDSN()
+	dbConfig := GetConfig()
+
+	dialector, err := dbConfig.Dialector()
+	if err != nil {
+		logging.GetLogger("root").WithError(err).Fatal("New Engine failed")
+	}
+
+	dbLogger, err := NewLogger(dbConfig.Log, dbConfig.literalQuote())
+	if err != nil {
+		logging.GetLogger("root").WithError(err).Fatal("New Engine failed")
+	}
 
 	// init engine
-	db, err := gorm.Open(mysql.Open(dbString), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: dbLogger,
 	})
 
 	if err != nil {
 		logging.GetLogger("root").WithError(err).Fatal("New Engine failed")
 	}
 
+	if err = registerCallbacks(db); err != nil {
+		logging.GetLogger("root").WithError(err).Fatal("New Engine failed")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		logging.GetLogger("root").WithError(err).Fatal("New Engine failed")
+	}
+	dbConfig.applyPool(sqlDB)
+
+	if len(dbConfig.Replicas) > 0 {
+		if err = dbConfig.useReplicas(context.Background(), db); err != nil {
+			logging.GetLogger("root").WithError(err).Fatal("New Engine replicas failed")
+		}
+	}
+
 	Engine = db
 }
 
+func (c Config) applyPool(sqlDB *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime))
+	}
+	if c.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTime))
+	}
+}
+
 func GetConfig() (dbConfig Config) {
 	dbYmlFile := fmt.Sprintf("%s/database.yml", constants.ConfigPath)
 	dbContent, readErr := ioutil.ReadFile(dbYmlFile)
@@ -61,15 +147,15 @@ func GetConfig() (dbConfig Config) {
 }
 
 func GetDSN() string {
-	dbConfig := GetConfig()
+	dsn, err := GetConfig().DSN()
+	if err != nil {
+		panic(err.Error())
+	}
 
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?"+
-		"charset=utf8&parseTime=true&loc=Local&timeout=10s",
-		dbConfig.Username, dbConfig.Password,
-		dbConfig.Host, dbConfig.Port, dbConfig.Database)
+	return dsn
 }
 
-// TxErrDefer commit or revert tx based on err, passing err to return
+// TxErrDefer commit or revert tx based on err, passing err to return, prefer WithTx in new code
 func TxErrDefer(tx *gorm.DB, err error) error {
 	if r := recover(); r != nil {
 		tx.Rollback()
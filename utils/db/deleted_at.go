@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DeletedAt is gorm.DeletedAt formatted as JSONTime, a model with it is soft deleted by gorm,
+// queries skip deleted rows unless Unscoped
+type DeletedAt sql.NullTime
+
+func (t *DeletedAt) Scan(value interface{}) error {
+	return (*sql.NullTime)(t).Scan(value)
+}
+
+func (t DeletedAt) Value() (driver.Value, error) {
+	if !t.Valid {
+		return nil, nil
+	}
+
+	return t.Time, nil
+}
+
+// MarshalJSON on DeletedAt format Time field with %Y-%m-%d %H:%M:%S, null if not deleted
+func (t DeletedAt) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+
+	return []byte(fmt.Sprintf("\"%s\"", t.Time.Format("2006-01-02 15:04:05"))), nil
+}
+
+func (t *DeletedAt) UnmarshalJSON(data []byte) (err error) {
+	if string(data) == "null" {
+		t.Valid = false
+		return nil
+	}
+
+	if t.Time, err = time.Parse("\"2006-01-02 15:04:05\"", string(data)); err != nil {
+		return
+	}
+	t.Valid = true
+
+	return
+}
+
+func (DeletedAt) QueryClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{gorm.SoftDeleteQueryClause{Field: f}}
+}
+
+func (DeletedAt) UpdateClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{gorm.SoftDeleteUpdateClause{Field: f}}
+}
+
+func (DeletedAt) DeleteClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{gorm.SoftDeleteDeleteClause{Field: f}}
+}
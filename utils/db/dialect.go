@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	// SQLiteMemory is a database in the process shared by all its connections, e.g. for tests
+	SQLiteMemory = "file::memory:?cache=shared"
+
+	// mysqlTLSName is the name TLSConfig files are registered by to the mysql driver
+	mysqlTLSName = "cygnus"
+)
+
+// dialect builds the DSN of a driver and opens it with the gorm driver
+type dialect struct {
+	dsn  func(c Config) (string, error)
+	open func(dsn string) gorm.Dialector
+	// quote of string literals in sql rendered by the driver for logging
+	quote string
+
+	// replicas are opened as driverName of database/sql and handed to gorm by withConn, nil if not supported
+	driverName string
+	withConn   func(conn *sql.DB) gorm.Dialector
+	lag        func(ctx context.Context, db *sql.DB) (time.Duration, error)
+}
+
+var dialects = map[string]dialect{
+	DriverMySQL: {
+		dsn:        mysqlDSN,
+		open:       mysql.Open,
+		quote:      "'",
+		driverName: "mysql",
+		withConn: func(conn *sql.DB) gorm.Dialector {
+			// no SELECT VERSION() at registration, a replica down at boot is evicted by its check instead
+			return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+		},
+		lag: mysqlLag,
+	},
+	DriverPostgres: {
+		dsn:        postgresDSN,
+		open:       postgres.Open,
+		quote:      "'",
+		driverName: "pgx",
+		withConn: func(conn *sql.DB) gorm.Dialector {
+			return postgres.New(postgres.Config{Conn: conn})
+		},
+		lag: postgresLag,
+	},
+	DriverSQLite: {dsn: sqliteDSN, open: sqlite.Open, quote: `"`},
+}
+
+func (c Config) dialect() (dialect, error) {
+	driver := c.Driver
+	if driver == "" {
+		driver = DriverMySQL
+	}
+
+	d, ok := dialects[driver]
+	if !ok {
+		return dialect{}, fmt.Errorf("unknown database driver %q", c.Driver)
+	}
+
+	return d, nil
+}
+
+// literalQuote of the configured driver, see NewLogger
+func (c Config) literalQuote() string {
+	d, _ := c.dialect()
+	return d.quote
+}
+
+// DSN of the configured driver
+func (c Config) DSN() (string, error) {
+	d, err := c.dialect()
+	if err != nil {
+		return "", err
+	}
+
+	return d.dsn(c)
+}
+
+// Dialector opens the configured driver for gorm.Open
+func (c Config) Dialector() (gorm.Dialector, error) {
+	d, err := c.dialect()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn, err := d.dsn(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.open(dsn), nil
+}
+
+// mysqlDSN is like user:password@tcp(host:port)/database?charset=utf8&parseTime=true&loc=Local&timeout=10s
+func mysqlDSN(c Config) (string, error) {
+	conf := mysqldriver.NewConfig()
+	conf.User = c.Username
+	conf.Passwd = c.Password
+	conf.Net = "tcp"
+	conf.Addr = net.JoinHostPort(c.Host, c.Port)
+	conf.DBName = c.Database
+	conf.ParseTime = true
+	conf.Timeout = c.dialTimeout()
+	conf.ReadTimeout = time.Duration(c.ReadTimeout)
+	conf.WriteTimeout = time.Duration(c.WriteTimeout)
+
+	conf.Loc = time.Local
+	if c.Loc != "" {
+		loc, err := time.LoadLocation(c.Loc)
+		if err != nil {
+			return "", fmt.Errorf("invalid database loc %q: %s", c.Loc, err)
+		}
+		conf.Loc = loc
+	}
+
+	if c.Collation != "" {
+		conf.Collation = c.Collation
+	}
+
+	// SET NAMES of a charset resets the collation to the default of the charset, so utf8 is
+	// only the default without a collation, which is sent on connect and implies its charset
+	charset := c.Charset
+	if charset == "" && c.Collation == "" {
+		charset = "utf8"
+	}
+
+	// params are parsed again by the driver, so options like multiStatements still work
+	conf.Params = map[string]string{}
+	if charset != "" {
+		conf.Params["charset"] = charset
+	}
+	for k, v := range c.Params {
+		conf.Params[k] = v
+	}
+
+	if c.TLS != nil {
+		tlsName, err := c.TLS.mysqlTLS()
+		if err != nil {
+			return "", err
+		}
+		conf.TLSConfig = tlsName
+	}
+
+	return conf.FormatDSN(), nil
+}
+
+// mysqlTLS registers the files of c to the mysql driver if any, the name for DSN tls param is returned
+func (c *TLSConfig) mysqlTLS() (string, error) {
+	if c.CAFile == "" && c.CertFile == "" {
+		return c.Mode, nil
+	}
+
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.Mode == "skip-verify",
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("read database tls ca_file error: %s", err)
+		}
+
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificate in database tls ca_file %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("load database tls client certificate error: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if err := mysqldriver.RegisterTLSConfig(mysqlTLSName, conf); err != nil {
+		return "", err
+	}
+
+	return mysqlTLSName, nil
+}
+
+// postgresDSN is like host=host port=port user=user password=password dbname=database sslmode=disable
+func postgresDSN(c Config) (string, error) {
+	pairs := [][2]string{
+		{"host", c.Host},
+		{"port", c.Port},
+		{"user", c.Username},
+		{"password", c.Password},
+		{"dbname", c.Database},
+		{"connect_timeout", strconv.Itoa(int(c.dialTimeout().Seconds()))},
+	}
+
+	if c.TLS != nil {
+		pairs = append(pairs,
+			[2]string{"sslmode", c.TLS.Mode},
+			[2]string{"sslrootcert", c.TLS.CAFile},
+			[2]string{"sslcert", c.TLS.CertFile},
+			[2]string{"sslkey", c.TLS.KeyFile},
+		)
+	}
+
+	keys := make([]string, 0, len(c.Params))
+	for k := range c.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		pairs = append(pairs, [2]string{k, c.Params[k]})
+	}
+
+	var parts []string
+	for _, pair := range pairs {
+		if pair[1] == "" {
+			continue
+		}
+
+		// quote values with spaces or quotes, as libpq does
+		value := pair[1]
+		if strings.ContainsAny(value, ` '\`) {
+			value = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+		}
+
+		parts = append(parts, pair[0]+"="+value)
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// sqliteDSN is the database file with Params as query, SQLiteMemory if no path
+func sqliteDSN(c Config) (string, error) {
+	path := c.Path
+	if path == "" {
+		path = SQLiteMemory
+	}
+
+	if len(c.Params) == 0 {
+		return path, nil
+	}
+
+	params := url.Values{}
+	for k, v := range c.Params {
+		params.Set(k, v)
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	return path + separator + params.Encode(), nil
+}
+
+func (c Config) dialTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+
+	return DefaultDialTimeout
+}
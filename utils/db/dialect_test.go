@@ -0,0 +1,117 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	base := Config{Host: "db", Port: "3306", Username: "user", Password: "p@ss", Database: "cygnus"}
+
+	cases := []struct {
+		name      string
+		conf      func(c Config) Config
+		charset   string
+		collation string
+	}{
+		{"defaults", func(c Config) Config { return c }, "utf8", "utf8mb4_general_ci"},
+		{"charset", func(c Config) Config {
+			c.Charset = "utf8mb4"
+			return c
+		}, "utf8mb4", "utf8mb4_general_ci"},
+		{"collation implies its charset", func(c Config) Config {
+			c.Collation = "utf8mb4_unicode_ci"
+			return c
+		}, "", "utf8mb4_unicode_ci"},
+		{"charset and collation", func(c Config) Config {
+			c.Charset, c.Collation = "utf8mb4", "utf8mb4_bin"
+			return c
+		}, "utf8mb4", "utf8mb4_bin"},
+		{"params override", func(c Config) Config {
+			c.Params = map[string]string{"charset": "latin1"}
+			return c
+		}, "latin1", "utf8mb4_general_ci"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dsn, err := mysqlDSN(c.conf(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conf, err := mysqldriver.ParseDSN(dsn)
+			if err != nil {
+				t.Fatalf("parse %s: %s", dsn, err)
+			}
+
+			if conf.User != "user" || conf.Passwd != "p@ss" || conf.Addr != "db:3306" || conf.DBName != "cygnus" {
+				t.Fatalf("dsn %s, want user:p@ss@tcp(db:3306)/cygnus", dsn)
+			}
+			if !conf.ParseTime || conf.Loc != time.Local || conf.Timeout != DefaultDialTimeout {
+				t.Fatalf("dsn %s, want parseTime, loc Local and timeout %s", dsn, DefaultDialTimeout)
+			}
+			if conf.Params["charset"] != c.charset || conf.Collation != c.collation {
+				t.Fatalf("dsn %s, want charset %q collation %q", dsn, c.charset, c.collation)
+			}
+		})
+	}
+
+	conf := base
+	conf.Loc = "Nowhere/Town"
+	if _, err := mysqlDSN(conf); err == nil {
+		t.Fatal("invalid loc passed")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	conf := Config{
+		Driver:   DriverPostgres,
+		Host:     "db",
+		Port:     "5432",
+		Username: "user",
+		Password: `it's a pass\word`,
+		Database: "cygnus",
+		Timeout:  Duration(3 * time.Second),
+		TLS:      &TLSConfig{Mode: "verify-full", CAFile: "/etc/ca.pem"},
+		Params:   map[string]string{"search_path": "app", "application_name": "cygnus"},
+	}
+
+	dsn, err := conf.DSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `host=db port=5432 user=user password='it\'s a pass\\word' dbname=cygnus connect_timeout=3 ` +
+		`sslmode=verify-full sslrootcert=/etc/ca.pem application_name=cygnus search_path=app`
+	if dsn != want {
+		t.Fatalf("dsn %s, want %s", dsn, want)
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	cases := []struct {
+		path   string
+		params map[string]string
+		want   string
+	}{
+		{"", nil, SQLiteMemory},
+		{"", map[string]string{"_foreign_keys": "1"}, SQLiteMemory + "&_foreign_keys=1"},
+		{"/var/lib/cygnus.db", nil, "/var/lib/cygnus.db"},
+		{"/var/lib/cygnus.db", map[string]string{"_foreign_keys": "1", "_busy_timeout": "5000"},
+			"/var/lib/cygnus.db?_busy_timeout=5000&_foreign_keys=1"},
+	}
+
+	for _, c := range cases {
+		dsn, err := Config{Driver: DriverSQLite, Path: c.path, Params: c.params}.DSN()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dsn != c.want {
+			t.Fatalf("dsn %s, want %s", dsn, c.want)
+		}
+	}
+}
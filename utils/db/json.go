@@ -23,13 +23,16 @@ func (j *JSON) Scan(value interface{}) error {
 		return nil
 	}
 
-	s, ok := value.([]byte)
-	if !ok {
+	// sqlite and postgres drivers may scan text columns as string
+	switch s := value.(type) {
+	case []byte:
+		*j = append((*j)[0:0], s...)
+	case string:
+		*j = append((*j)[0:0], s...)
+	default:
 		return errors.New("invalid scan source")
 	}
 
-	*j = append((*j)[0:0], s...)
-
 	return nil
 }
 
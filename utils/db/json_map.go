@@ -27,5 +27,10 @@ func (e *JSONMap) Scan(value interface{}) error {
 		return nil
 	}
 
+	// sqlite and postgres drivers may scan text columns as string
+	if s, ok := value.(string); ok {
+		return json.Unmarshal([]byte(s), e)
+	}
+
 	return json.Unmarshal(value.([]byte), e)
 }
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"go-cygnus/utils/logging"
+)
+
+const (
+	DefaultSlowThreshold = 200 * time.Millisecond
+
+	// LogLevelNone turns logging of normal queries off
+	LogLevelNone = "none"
+
+	redacted = "***"
+)
+
+// LogConfig of sql statements, they are logged by logging.GetLogger("db")
+type LogConfig struct {
+	// Level of normal queries, a logrus level or none, debug if not set
+	Level string `json:"level"`
+	// SlowLevel of queries over SlowThreshold, warn if not set
+	SlowLevel     string   `json:"slow_level"`
+	SlowThreshold Duration `json:"slow_threshold"`
+	// RedactLiterals replaces every string literal of logged sql, e.g. 'secret' is logged as '***',
+	// sqlite renders them in double quotes
+	RedactLiterals bool `json:"redact_literals"`
+	// Redact are regexps replaced in logged sql, the first group is kept if any, e.g. (password = )'[^']*'
+	Redact []string `json:"redact"`
+}
+
+// gormLogger adapts gorm logger.Interface to the project logger
+type gormLogger struct {
+	logger        *logging.ConvenientErrorLogger
+	mode          logger.LogLevel
+	level         logrus.Level
+	quiet         bool // normal queries are not logged
+	slowLevel     logrus.Level
+	slowThreshold time.Duration
+	redact        []*regexp.Regexp
+	literals      *regexp.Regexp // nil unless RedactLiterals
+	quote         string
+}
+
+// literalPattern matches literals quoted by quote, escaped by a backslash or doubled quote
+func literalPattern(quote string) *regexp.Regexp {
+	q := regexp.QuoteMeta(quote)
+	return regexp.MustCompile(q + `(?:[^` + q + `\\]|\\.|` + q + q + `)*` + q)
+}
+
+// NewLogger builds the gorm logger of conf, db.Debug() still logs normal queries if they are turned off.
+// quote is the one the driver renders string literals in, single quote if empty
+func NewLogger(conf LogConfig, quote string) (logger.Interface, error) {
+	l := &gormLogger{
+		logger:        logging.GetLogger("db"),
+		mode:          logger.Info,
+		level:         logrus.DebugLevel,
+		slowLevel:     logrus.WarnLevel,
+		slowThreshold: DefaultSlowThreshold,
+	}
+
+	switch conf.Level {
+	case "":
+	case LogLevelNone:
+		l.quiet = true
+		l.mode = logger.Warn
+	default:
+		level, err := logrus.ParseLevel(conf.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid db log level: %s", err)
+		}
+		l.level = level
+	}
+
+	if conf.SlowLevel != "" {
+		level, err := logrus.ParseLevel(conf.SlowLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid db log slow_level: %s", err)
+		}
+		l.slowLevel = level
+	}
+
+	if conf.SlowThreshold > 0 {
+		l.slowThreshold = time.Duration(conf.SlowThreshold)
+	}
+
+	if conf.RedactLiterals {
+		if quote == "" {
+			quote = "'"
+		}
+		l.literals, l.quote = literalPattern(quote), quote
+	}
+
+	for _, pattern := range conf.Redact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid db log redact %q: %s", pattern, err)
+		}
+		l.redact = append(l.redact, re)
+	}
+
+	return l, nil
+}
+
+func (l *gormLogger) LogMode(mode logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.mode = mode
+	copied.quiet = false
+
+	return &copied
+}
+
+func (l *gormLogger) entry(ctx context.Context) *logging.ConvenientErrorLogger {
+	if reqID := logging.ReqIDFromContext(ctx); reqID != "" {
+		return l.logger.WithField("req_id", reqID)
+	}
+
+	return l.logger
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.mode >= logger.Info {
+		l.entry(ctx).Infof(msg, data...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.mode >= logger.Warn {
+		l.entry(ctx).Warnf(msg, data...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.mode >= logger.Error {
+		l.entry(ctx).Errorf(msg, data...)
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.mode <= logger.Silent {
+		return
+	}
+
+	cost := time.Since(begin)
+	isError := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+	isSlow := l.slowThreshold > 0 && cost > l.slowThreshold
+
+	switch {
+	case isError && l.mode >= logger.Error:
+	case isSlow && l.mode >= logger.Warn:
+	case !l.quiet && l.mode >= logger.Info:
+	default:
+		return
+	}
+
+	sql, rows := fc()
+
+	e := l.entry(ctx).WithField("sql", l.redactSQL(sql)).WithField("rows", rows).WithField("cost", cost.Seconds())
+
+	switch {
+	case isError:
+		e.WithError(err).Error("query failed")
+	case isSlow:
+		e.WithField("slow_threshold", l.slowThreshold.Seconds()).Log(l.slowLevel, "slow query")
+	default:
+		e.Log(l.level, "query")
+	}
+}
+
+func (l *gormLogger) redactSQL(sql string) string {
+	if l.literals != nil {
+		sql = l.literals.ReplaceAllLiteralString(sql, l.quote+redacted+l.quote)
+	}
+
+	for _, re := range l.redact {
+		switch {
+		case re.NumSubexp() == 0:
+			sql = re.ReplaceAllString(sql, redacted)
+		default:
+			sql = re.ReplaceAllString(sql, "${1}"+redacted)
+		}
+	}
+
+	return sql
+}
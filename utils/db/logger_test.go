@@ -0,0 +1,46 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRedactLiterals(t *testing.T) {
+	const query = "SELECT * FROM `accounts` WHERE `key` = ? AND `value` = ? AND `id` = ?"
+
+	for driver, dialector := range map[string]gorm.Dialector{
+		DriverSQLite: sqlite.Dialector{},
+		DriverMySQL:  mysql.Dialector{Config: &mysql.Config{}},
+	} {
+		l, err := NewLogger(LogConfig{RedactLiterals: true}, Config{Driver: driver}.literalQuote())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sql := l.(*gormLogger).redactSQL(dialector.Explain(query, "password", `se'c"ret`, 7))
+
+		if strings.Contains(sql, "password") || strings.Contains(sql, "ret") {
+			t.Errorf("%s: literals left in %s", driver, sql)
+		}
+		if !strings.Contains(sql, "`key`") || !strings.Contains(sql, "= 7") {
+			t.Errorf("%s: more than literals redacted in %s", driver, sql)
+		}
+	}
+}
+
+func TestRedactPatterns(t *testing.T) {
+	l, err := NewLogger(LogConfig{Redact: []string{`(token = )'[^']*'`, `\d{11}`}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql := l.(*gormLogger).redactSQL("UPDATE users SET token = 'abc', phone = '13800000000' WHERE name = 'bob'")
+
+	if want := "UPDATE users SET token = ***, phone = '***' WHERE name = 'bob'"; sql != want {
+		t.Fatalf("redacted %s, want %s", sql, want)
+	}
+}
@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// lockRetryInterval of postgres, which has no waiting advisory lock with timeout
+const lockRetryInterval = time.Second
+
+// withLock runs fn while holding a database wide lock named after Table, so two pods never migrate at once.
+// mysql GET_LOCK and postgres advisory locks are per session, fn runs on the dedicated connection holding it.
+func (m *Migrator) withLock(ctx context.Context, fn func(db *gorm.DB) error) (err error) {
+	db := m.db.WithContext(ctx)
+
+	// sqlite locks the database file on writes, a dedicated connection would only starve a pool of one
+	if dialect := db.Dialector.Name(); dialect != "mysql" && dialect != "postgres" {
+		if err = m.ensureTable(db); err != nil {
+			return
+		}
+
+		return fn(db)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn, db.Dialector.Name())
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if db, err = onConn(ctx, db, sqlDB, conn); err != nil {
+		return
+	}
+
+	if err = m.ensureTable(db); err != nil {
+		return
+	}
+
+	return fn(db)
+}
+
+// onConn opens db again on conn. It is a new gorm.DB rather than a session of db,
+// dbresolver would switch statements outside transactions back to the pool.
+func onConn(ctx context.Context, db *gorm.DB, sqlDB *sql.DB, conn *sql.Conn) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch d := db.Dialector.(type) {
+	case *mysql.Dialector:
+		conf := *d.Config
+		conf.Conn = conn
+		dialector = mysql.New(conf)
+	case *postgres.Dialector:
+		// postgres takes a *sql.DB only, it is replaced by conn once opened
+		conf := *d.Config
+		conf.Conn = sqlDB
+		dialector = postgres.New(conf)
+	default:
+		return nil, fmt.Errorf("migration lock of %s dialector %T not supported", db.Dialector.Name(), db.Dialector)
+	}
+
+	locked, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         db.Logger,
+		NamingStrategy: db.NamingStrategy,
+		NowFunc:        db.NowFunc,
+		// a ping of sqlDB waits forever on a pool of one held by conn
+		DisableAutomaticPing:                     true,
+		DisableForeignKeyConstraintWhenMigrating: db.DisableForeignKeyConstraintWhenMigrating,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	locked.ConnPool = conn
+	locked.Statement.ConnPool = conn
+
+	return locked.WithContext(ctx), nil
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn, dialect string) (unlock func(), err error) {
+	timeout := m.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	switch dialect {
+	case "mysql":
+		var acquired sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.Table, int(timeout.Seconds())).Scan(&acquired)
+		if err != nil {
+			return
+		}
+		if acquired.Int64 != 1 {
+			return nil, fmt.Errorf("migration locked by another process over %s", timeout)
+		}
+
+		return func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", m.Table); err != nil {
+				m.logger.WithError(err).Warn("release migration lock failed")
+			}
+		}, nil
+	case "postgres":
+		key := lockKey(m.Table)
+		deadline := time.Now().Add(timeout)
+
+		for {
+			var acquired bool
+			if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+				return
+			}
+			if acquired {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("migration locked by another process over %s", timeout)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(lockRetryInterval):
+			}
+		}
+
+		return func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+				m.logger.WithError(err).Warn("release migration lock failed")
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("migration lock of %s not supported", dialect)
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+
+	return int64(h.Sum64())
+}
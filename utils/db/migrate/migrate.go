@@ -0,0 +1,343 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"go-cygnus/utils/logging"
+)
+
+const (
+	DefaultTable       = "schema_migrations"
+	DefaultLockTimeout = time.Minute
+)
+
+// Migration is a versioned schema change, Up and Down take precedence over UpSQL and DownSQL.
+// Versions are compared as strings, a timestamp like 20210901120000 keeps them in order.
+type Migration struct {
+	Version string
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	// UpSQL and DownSQL are run as one Exec, mysql needs multiStatements param for several statements
+	UpSQL   string
+	DownSQL string
+}
+
+func (m *Migration) up(tx *gorm.DB) error {
+	if m.Up != nil {
+		return m.Up(tx)
+	}
+
+	return tx.Exec(m.UpSQL).Error
+}
+
+func (m *Migration) down(tx *gorm.DB) error {
+	switch {
+	case m.Down != nil:
+		return m.Down(tx)
+	case m.DownSQL != "":
+		return tx.Exec(m.DownSQL).Error
+	}
+
+	return fmt.Errorf("migration %s_%s can not be rolled back", m.Version, m.Name)
+}
+
+// record is a row of the history table
+type record struct {
+	Version   string `gorm:"primaryKey;size:64"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// Status of a migration, migrations applied but unknown to the code are listed with an empty Name
+type Status struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations, the history is kept in Table
+type Migrator struct {
+	Table       string
+	LockTimeout time.Duration
+
+	db         *gorm.DB
+	migrations []*Migration
+	logger     *logging.ConvenientErrorLogger
+}
+
+// New sorts migrations by version, reads and writes of engine always go to the primary
+func New(engine *gorm.DB, migrations ...*Migration) *Migrator {
+	sorted := append([]*Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		Table:       DefaultTable,
+		LockTimeout: DefaultLockTimeout,
+		db:          engine.Clauses(dbresolver.Write).Session(&gorm.Session{}),
+		migrations:  sorted,
+		logger:      logging.GetLogger("migrate"),
+	}
+}
+
+// Run executes a command: up, down, status or "to <version>", to 0 rolls back everything
+func (m *Migrator) Run(ctx context.Context, command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("empty migrate command")
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		return m.Up(ctx)
+	case args[0] == "down" && len(args) == 1:
+		return m.Down(ctx)
+	case args[0] == "to" && len(args) == 2:
+		return m.To(ctx, args[1])
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			l := m.logger.WithField("version", s.Version).WithField("name", s.Name)
+			if s.Applied {
+				l.WithField("applied_at", s.AppliedAt).Info("applied")
+			} else {
+				l.Info("pending")
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q, use up, down, status or to <version>", command)
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.rollback(db, m.migrations[i])
+			}
+		}
+
+		m.logger.Info("nothing to roll back")
+
+		return nil
+	})
+}
+
+// To applies migrations up to version and rolls back the ones after it
+func (m *Migrator) To(ctx context.Context, version string) error {
+	if version != "0" && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %s", version)
+	}
+
+	return m.withLock(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				if err = m.rollback(db, migration); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err = m.apply(db, migration); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status lists every migration in version order
+func (m *Migrator) Status(ctx context.Context) (statuses []Status, err error) {
+	db := m.db.WithContext(ctx)
+	if err = m.ensureTable(db); err != nil {
+		return
+	}
+
+	applied, err := m.applied(db)
+	if err != nil {
+		return
+	}
+
+	for _, migration := range m.migrations {
+		s := Status{Version: migration.Version, Name: migration.Name}
+		if r, ok := applied[migration.Version]; ok {
+			s.Applied, s.AppliedAt = true, r.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, s)
+	}
+
+	for _, r := range applied {
+		statuses = append(statuses, Status{Version: r.Version, Applied: true, AppliedAt: r.AppliedAt})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return
+}
+
+func (m *Migrator) find(version string) *Migration {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) ensureTable(db *gorm.DB) error {
+	return db.Table(m.Table).AutoMigrate(&record{})
+}
+
+func (m *Migrator) applied(db *gorm.DB) (applied map[string]record, err error) {
+	var records []record
+	if err = db.Table(m.Table).Find(&records).Error; err != nil {
+		return
+	}
+
+	applied = make(map[string]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+
+	return
+}
+
+// apply runs a migration and records it in one transaction, mysql DDL commits implicitly though
+func (m *Migrator) apply(db *gorm.DB, migration *Migration) error {
+	start := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := migration.up(tx); err != nil {
+			return err
+		}
+
+		return tx.Table(m.Table).Create(&record{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s_%s up failed: %s", migration.Version, migration.Name, err)
+	}
+
+	m.logger.WithField("version", migration.Version).WithField("name", migration.Name).
+		WithField("cost", time.Since(start).Seconds()).Info("migrated up")
+
+	return nil
+}
+
+func (m *Migrator) rollback(db *gorm.DB, migration *Migration) error {
+	start := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := migration.down(tx); err != nil {
+			return err
+		}
+
+		return tx.Table(m.Table).Where("version = ?", migration.Version).Delete(&record{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s_%s down failed: %s", migration.Version, migration.Name, err)
+	}
+
+	m.logger.WithField("version", migration.Version).WithField("name", migration.Name).
+		WithField("cost", time.Since(start).Seconds()).Info("migrated down")
+
+	return nil
+}
+
+var sqlFilePattern = regexp.MustCompile(`^([0-9]+)_(.+)\.(up|down)\.sql$`)
+
+// FromFS loads sql migrations in dir named like 20210901120000_add_account_index.up.sql
+// and 20210901120000_add_account_index.down.sql, e.g. from an embed.FS
+func FromFS(fsys fs.FS, dir string) (migrations []*Migration, err error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, entry := range entries {
+		matches := sqlFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		var content []byte
+		if content, err = fs.ReadFile(fsys, path.Join(dir, entry.Name())); err != nil {
+			return
+		}
+
+		version, name, direction := matches[1], matches[2], matches[3]
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+			migrations = append(migrations, migration)
+		}
+
+		if direction == "up" {
+			migration.UpSQL = string(content)
+		} else {
+			migration.DownSQL = string(content)
+		}
+	}
+
+	for _, migration := range migrations {
+		if migration.UpSQL == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up sql", migration.Version, migration.Name)
+		}
+	}
+
+	return
+}
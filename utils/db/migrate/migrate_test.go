@@ -0,0 +1,118 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var testFS = fstest.MapFS{
+	"sql/20210901000000_create_items.up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY)")},
+	"sql/20210901000000_create_items.down.sql": {Data: []byte("DROP TABLE items")},
+	"sql/20210902000000_add_name.up.sql":       {Data: []byte("ALTER TABLE items ADD COLUMN name TEXT")},
+	"sql/README.md":                            {Data: []byte("ignored")},
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := FromFS(testFS, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(engine, migrations...), engine
+}
+
+// hasName tells whether items has the column of the second migration
+func hasName(engine *gorm.DB) bool {
+	return engine.Exec("SELECT name FROM items").Error == nil
+}
+
+func applied(t *testing.T, m *Migrator) (versions []string) {
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	m, engine := newTestMigrator(t)
+	ctx := context.Background()
+
+	if err := m.Run(ctx, "up"); err != nil {
+		t.Fatal(err)
+	}
+	if !hasName(engine) || len(applied(t, m)) != 2 {
+		t.Fatalf("applied %v after up", applied(t, m))
+	}
+
+	// the latest one has no down sql
+	if err := m.Down(ctx); err == nil {
+		t.Fatal("rolled back a migration without down")
+	}
+	if len(applied(t, m)) != 2 {
+		t.Fatalf("applied %v after failed down", applied(t, m))
+	}
+}
+
+func TestMigratorTo(t *testing.T) {
+	m, engine := newTestMigrator(t)
+	ctx := context.Background()
+
+	if err := m.To(ctx, "20210901000000"); err != nil {
+		t.Fatal(err)
+	}
+	if !engine.Migrator().HasTable("items") || hasName(engine) {
+		t.Fatal("not migrated to the first version only")
+	}
+
+	if err := m.Run(ctx, "to 0"); err != nil {
+		t.Fatal(err)
+	}
+	if engine.Migrator().HasTable("items") || len(applied(t, m)) != 0 {
+		t.Fatal("not rolled back to 0")
+	}
+
+	if err := m.To(ctx, "20990101000000"); err == nil {
+		t.Fatal("migrated to an unknown version")
+	}
+	if err := m.Run(ctx, "sideways"); err == nil {
+		t.Fatal("ran an unknown command")
+	}
+}
+
+func TestMigratorWithOneConnection(t *testing.T) {
+	m, engine := newTestMigrator(t)
+
+	sqlDB, err := engine.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !hasName(engine) {
+		t.Fatal("not migrated up")
+	}
+}
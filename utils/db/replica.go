@@ -0,0 +1,273 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"go-cygnus/utils/logging"
+)
+
+const DefaultReplicaCheckInterval = 10 * time.Second
+
+// ReplicaConfig is a read replica of the primary in Config, fields not set are taken from the primary
+type ReplicaConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ReplicaCheckConfig evicts a replica failing ping or lagging behind, it is back once healthy again
+type ReplicaCheckConfig struct {
+	// Interval of checks, DefaultReplicaCheckInterval if not set
+	Interval Duration `json:"interval"`
+	// MaxLag of replication, lag is not checked if not set
+	MaxLag Duration `json:"max_lag"`
+}
+
+// Primary forces queries of tx to the primary, e.g. reading right after a write
+func Primary(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(dbresolver.Write)
+}
+
+// replica config inherits everything but the address and credentials from the primary
+func (c Config) replica(r ReplicaConfig) Config {
+	conf := c
+	conf.Replicas = nil
+	conf.Host = r.Host
+
+	if r.Port != "" {
+		conf.Port = r.Port
+	}
+	if r.Username != "" {
+		conf.Username = r.Username
+		conf.Password = r.Password
+	}
+
+	return conf
+}
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// replicaPolicy picks a healthy replica at random, reads go to the primary if none is healthy.
+// The primary is registered as the last replica, so the policy is consulted even with one replica.
+type replicaPolicy struct {
+	primary  gorm.ConnPool
+	replicas map[gorm.ConnPool]*replica
+	check    ReplicaCheckConfig
+	lag      func(ctx context.Context, db *sql.DB) (time.Duration, error)
+	logger   *logging.ConvenientErrorLogger
+}
+
+func (p *replicaPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(connPools))
+	for _, connPool := range connPools {
+		if r, ok := p.replicas[connPool]; ok && r.isHealthy() {
+			healthy = append(healthy, connPool)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return p.primary
+	}
+
+	return healthy[rand.Intn(len(healthy))]
+}
+
+func (p *replicaPolicy) interval() time.Duration {
+	if p.check.Interval > 0 {
+		return time.Duration(p.check.Interval)
+	}
+
+	return DefaultReplicaCheckInterval
+}
+
+// run checks replicas until ctx is done
+func (p *replicaPolicy) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkAll(ctx)
+		}
+	}
+}
+
+// checkAll checks replicas concurrently, a replica down does not hold back the others
+func (p *replicaPolicy) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range p.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			p.checkReplica(ctx, r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (p *replicaPolicy) checkReplica(ctx context.Context, r *replica) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval())
+	defer cancel()
+
+	err := r.db.PingContext(ctx)
+	if err == nil && p.check.MaxLag > 0 && p.lag != nil {
+		var lag time.Duration
+		if lag, err = p.lag(ctx, r.db); err == nil && lag > time.Duration(p.check.MaxLag) {
+			err = fmt.Errorf("replication lag %s over %s", lag, time.Duration(p.check.MaxLag))
+		}
+	}
+
+	healthy := int32(1)
+	if err != nil {
+		healthy = 0
+	}
+
+	if atomic.SwapInt32(&r.healthy, healthy) == healthy {
+		return
+	}
+
+	if err != nil {
+		p.logger.WithError(err).WithField("replica", r.name).Warn("replica evicted")
+	} else {
+		p.logger.WithField("replica", r.name).Info("replica back")
+	}
+}
+
+// useReplicas routes reads of engine to the replicas of c, the checker stops with ctx
+func (c Config) useReplicas(ctx context.Context, engine *gorm.DB) error {
+	d, err := c.dialect()
+	if err != nil {
+		return err
+	}
+
+	if d.withConn == nil {
+		return fmt.Errorf("database driver %s has no replicas", c.Driver)
+	}
+
+	primary, err := engine.DB()
+	if err != nil {
+		return err
+	}
+
+	policy := &replicaPolicy{
+		primary:  primary,
+		replicas: make(map[gorm.ConnPool]*replica, len(c.Replicas)),
+		check:    c.ReplicaCheck,
+		lag:      d.lag,
+		logger:   logging.GetLogger("db"),
+	}
+
+	dialectors := make([]gorm.Dialector, 0, len(c.Replicas))
+	for _, replicaConfig := range c.Replicas {
+		conf := c.replica(replicaConfig)
+
+		dsn, err := d.dsn(conf)
+		if err != nil {
+			return err
+		}
+
+		sqlDB, err := sql.Open(d.driverName, dsn)
+		if err != nil {
+			return err
+		}
+		conf.applyPool(sqlDB)
+
+		policy.replicas[sqlDB] = &replica{name: net.JoinHostPort(conf.Host, conf.Port), db: sqlDB, healthy: 1}
+		dialectors = append(dialectors, d.withConn(sqlDB))
+	}
+
+	dialectors = append(dialectors, d.withConn(primary))
+
+	// no read goes to a replica before it is checked
+	policy.checkAll(ctx)
+
+	// dbresolver opens the replicas with the config of engine, a replica down must not fail the registration
+	ping := engine.Config.DisableAutomaticPing
+	engine.Config.DisableAutomaticPing = true
+	err = engine.Use(dbresolver.Register(dbresolver.Config{Replicas: dialectors, Policy: policy}))
+	engine.Config.DisableAutomaticPing = ping
+
+	if err != nil {
+		return err
+	}
+
+	go policy.run(ctx)
+
+	return nil
+}
+
+// mysqlLag is Seconds_Behind_Master of SHOW SLAVE STATUS, 0 if the server is no replica
+func mysqlLag(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil || !rows.Next() {
+		return 0, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err = rows.Scan(dest...); err != nil {
+		return
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" {
+			continue
+		}
+
+		if !values[i].Valid {
+			return 0, errors.New("replication stopped")
+		}
+
+		var seconds int64
+		if _, err = fmt.Sscan(values[i].String, &seconds); err != nil {
+			return
+		}
+
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return
+}
+
+// postgresLag is the age of the last replayed transaction, 0 if the server is no standby or has replayed
+// everything received, the age keeps growing while the primary is idle
+func postgresLag(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	var seconds float64
+	err = db.QueryRowContext(ctx, `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`).Scan(&seconds)
+
+	return time.Duration(seconds * float64(time.Second)), err
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"go-cygnus/utils/logging"
+)
+
+func openSQLite(t *testing.T, name string) *sql.DB {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), name+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func TestReplicaPolicy(t *testing.T) {
+	primary, fast, slow := openSQLite(t, "primary"), openSQLite(t, "fast"), openSQLite(t, "slow")
+
+	lags := map[*sql.DB]time.Duration{fast: 0, slow: time.Minute}
+	p := &replicaPolicy{
+		primary: primary,
+		replicas: map[gorm.ConnPool]*replica{
+			fast: {name: "fast", db: fast, healthy: 1},
+			slow: {name: "slow", db: slow, healthy: 1},
+		},
+		check: ReplicaCheckConfig{Interval: Duration(time.Second), MaxLag: Duration(time.Second)},
+		lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			return lags[db], nil
+		},
+		logger: logging.GetLogger("db"),
+	}
+	pools := []gorm.ConnPool{fast, slow, primary}
+	ctx := context.Background()
+
+	p.checkAll(ctx)
+	for i := 0; i < 10; i++ {
+		if got := p.Resolve(pools); got != fast {
+			t.Fatalf("resolved %v, want the replica not lagging", got)
+		}
+	}
+
+	// the lagging replica is back once caught up
+	lags[slow] = 0
+	p.checkAll(ctx)
+	if !p.replicas[slow].isHealthy() {
+		t.Fatal("caught up replica not back")
+	}
+
+	// reads fall back to the primary once no replica is healthy
+	_ = fast.Close()
+	_ = slow.Close()
+	p.checkAll(ctx)
+	if got := p.Resolve(pools); got != primary {
+		t.Fatalf("resolved %v, want the primary", got)
+	}
+}
+
+func TestUseReplicasWithReplicaDown(t *testing.T) {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "primary.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	_ = l.Close()
+
+	conf := Config{
+		Driver:       DriverMySQL,
+		Timeout:      Duration(time.Second),
+		Replicas:     []ReplicaConfig{{Host: host, Port: port}},
+		ReplicaCheck: ReplicaCheckConfig{Interval: Duration(time.Second)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = conf.useReplicas(ctx, engine); err != nil {
+		t.Fatalf("replica down at boot failed the engine: %s", err)
+	}
+
+	// the replica is evicted before serving, reads go to the primary
+	var one int
+	if err = engine.Raw("SELECT 1").Scan(&one).Error; err != nil || one != 1 {
+		t.Fatalf("read %d err %v, want 1 from the primary", one, err)
+	}
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultTxAttempts = 3
+
+	txRetryBaseDelay = 20 * time.Millisecond
+
+	// mysql errors worth running the whole transaction again
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+type txOptions struct {
+	sql.TxOptions
+	attempts int
+}
+
+type TxOption func(o *txOptions)
+
+// WithIsolation sets the isolation level, the database default if not set
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.Isolation = level
+	}
+}
+
+func ReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// WithAttempts bounds the runs of a transaction retried on deadlock, DefaultTxAttempts if not set
+func WithAttempts(attempts int) TxOption {
+	return func(o *txOptions) {
+		o.attempts = attempts
+	}
+}
+
+type txContextKey struct{}
+
+// FromContext returns the transaction of WithTx in ctx, or Engine bound to ctx
+func FromContext(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txContextKey{}).(*gorm.DB); ok {
+		return tx
+	}
+
+	return Engine.WithContext(ctx)
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back otherwise.
+// Called inside another WithTx, through ctx or FromContext, it runs in a savepoint of the outer
+// transaction and options are ignored. The outermost transaction is run again on mysql deadlock
+// or lock wait timeout, so fn must not have side effects besides tx.
+func WithTx(ctx context.Context, fn func(tx *gorm.DB) error, opts ...TxOption) (err error) {
+	if outer, ok := ctx.Value(txContextKey{}).(*gorm.DB); ok {
+		return outer.Transaction(func(tx *gorm.DB) error {
+			return fn(tx.WithContext(context.WithValue(ctx, txContextKey{}, tx)))
+		})
+	}
+
+	o := txOptions{attempts: DefaultTxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = Engine.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(tx.WithContext(context.WithValue(ctx, txContextKey{}, tx)))
+		}, &o.TxOptions)
+
+		if err == nil || !IsRetryableTxError(err) || attempt >= o.attempts || ctx.Err() != nil {
+			return
+		}
+
+		delay := time.Duration(attempt) * txRetryBaseDelay
+		delay += time.Duration(rand.Int63n(int64(delay)))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// IsRetryableTxError tells whether err is a mysql deadlock or lock wait timeout
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+
+	return false
+}
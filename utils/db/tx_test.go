@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type txItem struct {
+	ID   uint
+	Name string
+}
+
+// useTestEngine points Engine to a fresh sqlite database with tables of models
+func useTestEngine(t *testing.T, models ...interface{}) *gorm.DB {
+	engine, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registerCallbacks(engine); err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := Engine
+	Engine = engine
+	t.Cleanup(func() {
+		Engine = saved
+	})
+
+	return engine
+}
+
+func countItems(t *testing.T, name string) (count int64) {
+	if err := Engine.Model(&txItem{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestWithTxCommitsAndRollsBack(t *testing.T) {
+	useTestEngine(t, &txItem{})
+	ctx := context.Background()
+
+	if err := WithTx(ctx, func(tx *gorm.DB) error {
+		return tx.Create(&txItem{Name: "committed"}).Error
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	if err := WithTx(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(&txItem{Name: "rolled back"}).Error; err != nil {
+			return err
+		}
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("err %v, want %v", err, failure)
+	}
+
+	if countItems(t, "committed") != 1 || countItems(t, "rolled back") != 0 {
+		t.Fatal("transactions not committed or rolled back")
+	}
+}
+
+func TestWithTxNestsInSavepoint(t *testing.T) {
+	useTestEngine(t, &txItem{})
+
+	err := WithTx(context.Background(), func(tx *gorm.DB) error {
+		ctx := tx.Statement.Context
+		if FromContext(ctx).Statement.ConnPool != tx.Statement.ConnPool {
+			t.Error("FromContext is not the transaction of ctx")
+		}
+
+		if err := FromContext(ctx).Create(&txItem{Name: "outer"}).Error; err != nil {
+			return err
+		}
+
+		// the failed inner transaction is rolled back alone
+		_ = WithTx(ctx, func(inner *gorm.DB) error {
+			if err := inner.Create(&txItem{Name: "inner"}).Error; err != nil {
+				return err
+			}
+			return errors.New("failure")
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if countItems(t, "outer") != 1 || countItems(t, "inner") != 0 {
+		t.Fatal("savepoint not rolled back alone")
+	}
+}
+
+func TestIsRetryableTxError(t *testing.T) {
+	for err, retryable := range map[error]bool{
+		&mysql.MySQLError{Number: mysqlErrDeadlock}:        true,
+		&mysql.MySQLError{Number: mysqlErrLockWaitTimeout}: true,
+		&mysql.MySQLError{Number: 1062}:                    false,
+		errors.New("deadlock"):                             false,
+	} {
+		if IsRetryableTxError(err) != retryable {
+			t.Errorf("IsRetryableTxError(%v) is %t", err, !retryable)
+		}
+	}
+}
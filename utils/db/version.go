@@ -0,0 +1,135 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Version of a row for optimistic locking, a model with it has the version bumped by every update,
+// and an update of a model holding a stale version fails with *ConflictError.
+// Rows start at version 1, a zero version skips the check, e.g. batch updates by conditions.
+type Version int64
+
+// ConflictError is an update of a row modified or deleted since its Version was read
+type ConflictError struct {
+	Table   string
+	ID      interface{}
+	Version Version
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %v was modified since version %d", e.Table, e.ID, e.Version)
+}
+
+// versionCheckKey of Statement.Settings, the versionCheck of the running update
+const versionCheckKey = "cygnus:version_check"
+
+type versionCheck struct {
+	field   *schema.Field
+	version Version
+}
+
+// VersionField of a model, nil if it has no Version
+func VersionField(s *schema.Schema) *schema.Field {
+	for _, f := range s.Fields {
+		if f.FieldType == versionType && f.DBName != "" {
+			return f
+		}
+	}
+
+	return nil
+}
+
+var versionType = reflect.TypeOf(Version(0))
+
+func (Version) UpdateClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{versionUpdateClause{Field: f}}
+}
+
+// versionUpdateClause bumps the version by sql and checks the version of the updated model
+type versionUpdateClause struct {
+	Field *schema.Field
+}
+
+func (c versionUpdateClause) Name() string {
+	return ""
+}
+
+func (c versionUpdateClause) Build(clause.Builder) {
+}
+
+func (c versionUpdateClause) MergeClause(*clause.Clause) {
+}
+
+func (c versionUpdateClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() != 0 {
+		return
+	}
+
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		if value, isZero := c.Field.ValueOf(stmt.ReflectValue); !isZero {
+			version := value.(Version)
+
+			// same as gorm soft delete, keep OR conditions apart from the version one
+			if w, ok := stmt.Clauses["WHERE"]; ok {
+				if where, ok := w.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+					for _, expr := range where.Exprs {
+						if orCond, ok := expr.(clause.OrConditions); ok && len(orCond.Exprs) == 1 {
+							where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+							w.Expression = where
+							stmt.Clauses["WHERE"] = w
+							break
+						}
+					}
+				}
+			}
+
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: c.Field.DBName}, Value: version},
+			}})
+			stmt.Settings.Store(versionCheckKey, versionCheck{field: c.Field, version: version})
+		}
+	}
+
+	// the version given by the model is never written, it is bumped after the assignments of SET
+	stmt.Omits = append(stmt.Omits, c.Field.DBName)
+
+	set := stmt.Clauses["SET"]
+	set.Name = "SET"
+	if set.Expression == nil {
+		set.Expression = clause.Set{}
+	}
+	column := clause.Column{Name: c.Field.DBName}
+	set.AfterExpression = clause.Expr{SQL: ", ? = ? + 1", Vars: []interface{}{column, column}}
+	stmt.Clauses["SET"] = set
+}
+
+// checkVersion fails updates which matched no row of the checked version, and bumps the version of the model
+func checkVersion(tx *gorm.DB) {
+	value, ok := tx.Statement.Settings.LoadAndDelete(versionCheckKey)
+	if !ok || tx.Error != nil {
+		return
+	}
+	check := value.(versionCheck)
+
+	if tx.Statement.RowsAffected == 0 {
+		conflict := &ConflictError{Table: tx.Statement.Table, Version: check.version}
+		if field := tx.Statement.Schema.PrioritizedPrimaryField; field != nil {
+			conflict.ID, _ = field.ValueOf(tx.Statement.ReflectValue)
+		}
+		_ = tx.AddError(conflict)
+
+		return
+	}
+
+	_ = check.field.Set(tx.Statement.ReflectValue, check.version+1)
+}
+
+// registerCallbacks adds the callbacks of the types of this package to db
+func registerCallbacks(db *gorm.DB) error {
+	return db.Callback().Update().After("gorm:update").Register("cygnus:check_version", checkVersion)
+}
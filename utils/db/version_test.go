@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+type versionedItem struct {
+	ID      uint
+	Name    string
+	Version Version `gorm:"not null;default:1"`
+}
+
+func TestVersionLocking(t *testing.T) {
+	engine := useTestEngine(t, &versionedItem{})
+
+	item := versionedItem{Name: "v1"}
+	if err := engine.Create(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var stale versionedItem
+	if err := engine.First(&stale, item.ID).Error; err != nil || stale.Version != 1 {
+		t.Fatalf("created version %d err %v, want 1", stale.Version, err)
+	}
+
+	fresh := stale
+	if err := engine.Model(&fresh).Update("name", "v2").Error; err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Version != 2 {
+		t.Fatalf("version %d after update, want 2", fresh.Version)
+	}
+
+	err := engine.Model(&stale).Update("name", "stale").Error
+
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Version != 1 || conflictErr.ID != item.ID {
+		t.Fatalf("err %v, want ConflictError of version 1", err)
+	}
+
+	// updates by conditions skip the check but still bump the version
+	if err = engine.Model(&versionedItem{}).Where("id = ?", item.ID).Update("name", "v3").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var saved versionedItem
+	if err = engine.First(&saved, item.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "v3" || saved.Version != 3 {
+		t.Fatalf("saved %+v, want v3 at version 3", saved)
+	}
+}
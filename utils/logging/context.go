@@ -0,0 +1,32 @@
+package logging
+
+import "context"
+
+type contextKey string
+
+const (
+	contextKeyReqID       contextKey = "req_id"
+	contextKeyTraceParent contextKey = "traceparent"
+)
+
+// ContextWithReqID binds the inbound request id to ctx, so that db and outbound calls can log and forward it
+func ContextWithReqID(ctx context.Context, reqID string) context.Context {
+	return context.WithValue(ctx, contextKeyReqID, reqID)
+}
+
+// ReqIDFromContext returns the request id bound by ContextWithReqID, empty if none
+func ReqIDFromContext(ctx context.Context) string {
+	reqID, _ := ctx.Value(contextKeyReqID).(string)
+	return reqID
+}
+
+// ContextWithTraceParent binds the inbound W3C traceparent header to ctx
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, contextKeyTraceParent, traceParent)
+}
+
+// TraceParentFromContext returns the traceparent bound by ContextWithTraceParent, empty if none
+func TraceParentFromContext(ctx context.Context) string {
+	traceParent, _ := ctx.Value(contextKeyTraceParent).(string)
+	return traceParent
+}